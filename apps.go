@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAppRefreshInterval = 5 * time.Minute
+	// minAppRefreshInterval limits refreshes triggered by messages from unknown applications.
+	minAppRefreshInterval = 10 * time.Second
+)
+
+// Application is a Gotify application as returned by the REST API.
+type Application struct {
+	ID          uint   `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Image       string `json:"image"`
+
+	// ImageURL is the absolute URL of Image.
+	ImageURL string `json:"image_url,omitempty"`
+}
+
+// appCache caches the applications of the client, which are fetched from the Gotify REST API.
+// Refreshes run in the background without holding the lock, so lookups of known applications
+// never wait for the REST API.
+type appCache struct {
+	baseURL         string
+	token           string
+	refreshInterval time.Duration
+
+	mu      sync.Mutex
+	apps    map[uint]*Application
+	updated time.Time
+	// refreshing is closed once the running refresh finished, nil if none is running.
+	refreshing chan struct{}
+	// err is the error of the last refresh.
+	err error
+}
+
+// appClient fetches the applications, the timeout bounds the wait for unknown applications.
+var appClient = &http.Client{Timeout: 5 * time.Second}
+
+func newAppCache(hostServer, token string, refreshInterval time.Duration) *appCache {
+	if refreshInterval <= 0 {
+		refreshInterval = defaultAppRefreshInterval
+	}
+	return &appCache{
+		baseURL:         restBaseURL(hostServer),
+		token:           token,
+		refreshInterval: refreshInterval,
+	}
+}
+
+// restBaseURL converts the websocket URL of the Gotify server to its HTTP URL.
+func restBaseURL(hostServer string) string {
+	base := strings.TrimRight(hostServer, "/")
+	if strings.HasPrefix(base, "wss://") {
+		return "https://" + strings.TrimPrefix(base, "wss://")
+	}
+	if strings.HasPrefix(base, "ws://") {
+		return "http://" + strings.TrimPrefix(base, "ws://")
+	}
+	return base
+}
+
+// get returns the application with the given ID. The cache is refreshed when it is outdated,
+// or when the application is unknown and the last refresh is not too recent. Known applications
+// are returned right away, outdated ones while the refresh runs, only unknown ones wait for a
+// running refresh.
+func (c *appCache) get(ctx context.Context, id uint) (*Application, error) {
+	c.mu.Lock()
+	app, ok := c.apps[id]
+	age := time.Since(c.updated)
+	var done chan struct{}
+	if age > c.refreshInterval || (!ok && age > minAppRefreshInterval) {
+		done = c.startRefresh()
+	} else if !ok {
+		done = c.refreshing
+	}
+	c.mu.Unlock()
+
+	if ok || done == nil {
+		return app, nil
+	}
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.apps[id], c.err
+}
+
+// startRefresh starts a refresh unless one is running and returns the channel closed once it
+// finished. The caller must hold c.mu.
+func (c *appCache) startRefresh() chan struct{} {
+	if c.refreshing != nil {
+		return c.refreshing
+	}
+	c.updated = time.Now()
+	done := make(chan struct{})
+	c.refreshing = done
+
+	go func() {
+		apps, err := c.fetch()
+		if err != nil {
+			logger.Warn("Failed to refresh applications", slog.Any("err", err))
+		}
+
+		c.mu.Lock()
+		// Keep serving outdated entries if the refresh failed, the next lookup retries.
+		if err == nil {
+			c.apps = apps
+		}
+		c.err = err
+		c.refreshing = nil
+		c.mu.Unlock()
+		close(done)
+	}()
+	return done
+}
+
+// fetch fetches the applications from the REST API.
+func (c *appCache) fetch() (map[uint]*Application, error) {
+	req, err := http.NewRequest(http.MethodGet, c.baseURL+"/application", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("X-Gotify-Key", c.token)
+
+	res, err := appClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch applications: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch applications: unexpected status code: %d", res.StatusCode)
+	}
+
+	var list []*Application
+	if err := json.NewDecoder(res.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("failed to decode applications: %w", err)
+	}
+
+	apps := make(map[uint]*Application, len(list))
+	for _, app := range list {
+		if app.Image != "" {
+			app.ImageURL = c.baseURL + "/" + strings.TrimLeft(app.Image, "/")
+		}
+		apps[app.ID] = app
+	}
+	return apps, nil
+}
+
+// AppList selects applications by ID or by name. In the config it is a single list whose numbers
+// are application IDs and whose strings are application names or globs.
+type AppList struct {
+	IDs   []uint
+	Names []string
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (l *AppList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var entries []interface{}
+	if err := unmarshal(&entries); err != nil {
+		return err
+	}
+	*l = AppList{}
+	for _, entry := range entries {
+		switch v := entry.(type) {
+		case int:
+			if v < 0 {
+				return fmt.Errorf("invalid application ID: %d", v)
+			}
+			l.IDs = append(l.IDs, uint(v))
+		case string:
+			l.Names = append(l.Names, v)
+		default:
+			return fmt.Errorf("invalid application %v: expected an ID or a name", entry)
+		}
+	}
+	return nil
+}
+
+// MarshalYAML implements yaml.Marshaler.
+func (l AppList) MarshalYAML() (interface{}, error) {
+	entries := make([]interface{}, 0, len(l.IDs)+len(l.Names))
+	for _, id := range l.IDs {
+		entries = append(entries, id)
+	}
+	for _, name := range l.Names {
+		entries = append(entries, name)
+	}
+	return entries, nil
+}
+
+// appAllowed reports whether messages of the application may be forwarded to the webhook,
+// matching the application ID against the IDs of Apps and its name against the name globs.
+func (w *WebHook) appAllowed(msg *MessageExternal) bool {
+	if len(w.Apps.IDs) == 0 && len(w.Apps.Names) == 0 {
+		return true
+	}
+
+	for _, appID := range w.Apps.IDs {
+		if appID == msg.ApplicationID {
+			return true
+		}
+	}
+
+	if msg.app == nil {
+		return false
+	}
+	for _, pattern := range w.Apps.Names {
+		if matched, _ := path.Match(pattern, msg.app.Name); matched {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestRestBaseURL(t *testing.T) {
+	assert.Equal(t, "http://localhost", restBaseURL("ws://localhost"))
+	assert.Equal(t, "https://gotify.example.com/sub", restBaseURL("wss://gotify.example.com/sub/"))
+	assert.Equal(t, "http://localhost:8080", restBaseURL("http://localhost:8080"))
+}
+
+func TestAppCache(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, "/application", r.URL.Path)
+		assert.Equal(t, "test-token", r.Header.Get("X-Gotify-Key"))
+		json.NewEncoder(w).Encode([]map[string]interface{}{
+			{"id": 1, "name": "backup-prod", "description": "Nightly backups", "image": "image/backup.png"},
+			{"id": 2, "name": "monitoring", "description": "Uptime checks", "image": "static/defaultapp.png"},
+		})
+	}))
+	defer server.Close()
+
+	cache := newAppCache("ws"+strings.TrimPrefix(server.URL, "http"), "test-token", time.Hour)
+	ctx := context.Background()
+
+	app, err := cache.get(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "backup-prod", app.Name)
+	assert.Equal(t, "Nightly backups", app.Description)
+	assert.Equal(t, server.URL+"/image/backup.png", app.ImageURL)
+
+	app, err = cache.get(ctx, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, "monitoring", app.Name)
+
+	// Unknown applications don't trigger a refresh right after the last one.
+	app, err = cache.get(ctx, 3)
+	assert.NoError(t, err)
+	assert.Nil(t, app)
+	assert.Equal(t, 1, requests)
+}
+
+func TestAppCacheRefreshInBackground(t *testing.T) {
+	release := make(chan struct{})
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) > 1 {
+			<-release
+		}
+		json.NewEncoder(w).Encode([]map[string]interface{}{{"id": 1, "name": "backup-prod"}})
+	}))
+	defer server.Close()
+	defer close(release)
+
+	cache := newAppCache("ws"+strings.TrimPrefix(server.URL, "http"), "test-token", time.Millisecond)
+	ctx := context.Background()
+
+	app, err := cache.get(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "backup-prod", app.Name)
+
+	// The outdated entry is served while the refresh hangs.
+	time.Sleep(5 * time.Millisecond)
+	start := time.Now()
+	app, err = cache.get(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "backup-prod", app.Name)
+	assert.Less(t, time.Since(start), time.Second)
+
+	// Unknown applications wait for the running refresh, at most until ctx is done.
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = cache.get(ctx, 2)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestAppListYAML(t *testing.T) {
+	var webhook WebHook
+	assert.NoError(t, yaml.Unmarshal([]byte("url: http://example.com\napps: [1, backup-*, 7, monitoring]"), &webhook))
+	assert.Equal(t, AppList{IDs: []uint{1, 7}, Names: []string{"backup-*", "monitoring"}}, webhook.Apps)
+
+	b, err := yaml.Marshal(&webhook)
+	assert.NoError(t, err)
+	var decoded WebHook
+	assert.NoError(t, yaml.Unmarshal(b, &decoded))
+	assert.Equal(t, webhook.Apps, decoded.Apps)
+
+	assert.Error(t, yaml.Unmarshal([]byte("apps: [-1]"), &webhook))
+	assert.Error(t, yaml.Unmarshal([]byte("apps: [{name: backup}]"), &webhook))
+}
+
+func TestWebHookAppAllowed(t *testing.T) {
+	msg := &MessageExternal{ApplicationID: 4, app: &Application{ID: 4, Name: "backup-prod"}}
+
+	assert.True(t, (&WebHook{}).appAllowed(msg))
+	assert.True(t, (&WebHook{Apps: AppList{IDs: []uint{4}}}).appAllowed(msg))
+	assert.False(t, (&WebHook{Apps: AppList{IDs: []uint{1}}}).appAllowed(msg))
+	assert.True(t, (&WebHook{Apps: AppList{IDs: []uint{1}, Names: []string{"backup-*"}}}).appAllowed(msg))
+	assert.False(t, (&WebHook{Apps: AppList{Names: []string{"monitoring"}}}).appAllowed(msg))
+
+	msg.app = nil
+	assert.False(t, (&WebHook{Apps: AppList{Names: []string{"*"}}}).appAllowed(msg))
+}
+
+func TestTemplateDataApplication(t *testing.T) {
+	msg := &MessageExternal{
+		Title: "Done",
+		app:   &Application{Name: "backup-prod", ImageURL: "http://gotify/image/backup.png"},
+	}
+
+	result, err := processTemplateString("[{{.app}}] {{.title}} {{.app_image}}", msg)
+	assert.NoError(t, err)
+	assert.Equal(t, "[backup-prod] Done http://gotify/image/backup.png", result)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Authentication types of webhooks.
+const (
+	AuthBasic  = "basic"
+	AuthBearer = "bearer"
+	AuthOAuth2 = "oauth2"
+)
+
+// tokenRefreshMargin is how long before its expiry an OAuth2 token is refreshed.
+const tokenRefreshMargin = 30 * time.Second
+
+// Auth authenticates the requests of a webhook.
+type Auth struct {
+	Type string `yaml:"type"`
+	// Username and Password of basic auth.
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// Token is a static bearer token.
+	Token string `yaml:"token"`
+	// TokenURL, ClientID, ClientSecret, Scopes and Params configure the OAuth2 client credentials
+	// grant.
+	TokenURL     string            `yaml:"token_url"`
+	ClientID     string            `yaml:"client_id"`
+	ClientSecret string            `yaml:"client_secret"`
+	Scopes       []string          `yaml:"scopes"`
+	Params       map[string]string `yaml:"params"`
+
+	mu          sync.Mutex
+	accessToken string
+	expiry      time.Time
+}
+
+func (a *Auth) compile() error {
+	if a == nil {
+		return nil
+	}
+	switch a.Type {
+	case AuthBasic:
+		if a.Username == "" {
+			return fmt.Errorf("username is required")
+		}
+	case AuthBearer:
+		if a.Token == "" {
+			return fmt.Errorf("token is required")
+		}
+	case AuthOAuth2:
+		if a.ClientID == "" {
+			return fmt.Errorf("client_id is required")
+		}
+		if err := checkDestination(a.TokenURL); err != nil {
+			return fmt.Errorf("invalid token_url: %w", err)
+		}
+	default:
+		return fmt.Errorf("unknown type %q", a.Type)
+	}
+	a.invalidate()
+	return nil
+}
+
+// apply authenticates the request.
+func (a *Auth) apply(ctx context.Context, req *http.Request) error {
+	if a == nil {
+		return nil
+	}
+	switch a.Type {
+	case AuthBasic:
+		req.SetBasicAuth(a.Username, a.Password)
+	case AuthBearer:
+		req.Header.Set("Authorization", "Bearer "+a.Token)
+	case AuthOAuth2:
+		token, err := a.oauth2Token(ctx)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return nil
+}
+
+// invalidate discards the cached OAuth2 token, e.g. after it was rejected.
+func (a *Auth) invalidate() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.accessToken = ""
+	a.expiry = time.Time{}
+}
+
+// oauth2Token returns the cached access token, requesting a new one shortly before it expires.
+func (a *Auth) oauth2Token(ctx context.Context) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.accessToken != "" && (a.expiry.IsZero() || time.Until(a.expiry) > tokenRefreshMargin) {
+		return a.accessToken, nil
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(a.Scopes) > 0 {
+		form.Set("scope", strings.Join(a.Scopes, " "))
+	}
+	for k, v := range a.Params {
+		form.Set(k, v)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(a.ClientID), url.QueryEscape(a.ClientSecret))
+
+	res, err := webhookClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to request token: %w", err)
+	}
+	defer res.Body.Close()
+
+	var token struct {
+		AccessToken      string `json:"access_token"`
+		TokenType        string `json:"token_type"`
+		ExpiresIn        int64  `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return "", fmt.Errorf("failed to read token response: %w", err)
+	}
+	// The response body is never included in errors since it holds the token.
+	if err := json.Unmarshal(body, &token); err != nil && res.StatusCode == http.StatusOK {
+		return "", fmt.Errorf("invalid token response")
+	}
+	if res.StatusCode != http.StatusOK {
+		if token.Error != "" {
+			return "", fmt.Errorf("token request failed with status code %d: %s %s", res.StatusCode, token.Error, token.ErrorDescription)
+		}
+		return "", fmt.Errorf("token request failed with status code %d", res.StatusCode)
+	}
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("token response has no access_token")
+	}
+	if token.TokenType != "" && !strings.EqualFold(token.TokenType, "bearer") {
+		return "", fmt.Errorf("unsupported token type %q", token.TokenType)
+	}
+
+	a.accessToken = token.AccessToken
+	a.expiry = time.Time{}
+	if token.ExpiresIn > 0 {
+		a.expiry = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+	logger.Debug("Fetched OAuth2 token", slog.String("client_id", a.ClientID), slog.Time("expiry", a.expiry))
+	return a.accessToken, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthCompile(t *testing.T) {
+	assert.Error(t, (&Auth{}).compile())
+	assert.Error(t, (&Auth{Type: "basic"}).compile())
+	assert.Error(t, (&Auth{Type: "bearer"}).compile())
+	assert.Error(t, (&Auth{Type: "oauth2", ClientID: "id"}).compile())
+	assert.Error(t, (&Auth{Type: "oauth2", TokenURL: "http://idp/token"}).compile())
+	assert.NoError(t, (&Auth{Type: "oauth2", ClientID: "id", TokenURL: "http://idp/token"}).compile())
+
+	defaultPolicy := destinationPolicy
+	destinationPolicy = &DestinationPolicy{}
+	defer func() { destinationPolicy = defaultPolicy }()
+	assert.ErrorContains(t, (&Auth{Type: "oauth2", ClientID: "id", TokenURL: "http://127.0.0.1/token"}).compile(), "is blocked")
+	assert.NoError(t, (&Auth{Type: "oauth2", ClientID: "id", TokenURL: "https://idp.example.com/token"}).compile())
+}
+
+func TestAuthApply(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	assert.NoError(t, (&Auth{Type: "basic", Username: "user", Password: "pass"}).apply(context.Background(), req))
+	username, password, ok := req.BasicAuth()
+	assert.True(t, ok)
+	assert.Equal(t, "user", username)
+	assert.Equal(t, "pass", password)
+
+	req = httptest.NewRequest(http.MethodPost, "/", nil)
+	assert.NoError(t, (&Auth{Type: "bearer", Token: "t0ken"}).apply(context.Background(), req))
+	assert.Equal(t, "Bearer t0ken", req.Header.Get("Authorization"))
+}
+
+func TestOAuth2Auth(t *testing.T) {
+	var (
+		issued   int32
+		expireIn = 3600
+	)
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		assert.NoError(t, r.ParseForm())
+		if id != "client" || secret != "s3cret" || r.Form.Get("grant_type") != "client_credentials" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error": "invalid_client"}`)
+			return
+		}
+		assert.Equal(t, "read write", r.Form.Get("scope"))
+		assert.Equal(t, "api", r.Form.Get("audience"))
+		n := atomic.AddInt32(&issued, 1)
+		fmt.Fprintf(w, `{"access_token": "token-%d", "token_type": "Bearer", "expires_in": %d}`, n, expireIn)
+	}))
+	defer idp.Close()
+
+	var authorizations []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		if r.Header.Get("Authorization") == "Bearer token-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer api.Close()
+
+	webhook := &WebHook{Url: api.URL, Method: "POST", Auth: &Auth{
+		Type: "oauth2", TokenURL: idp.URL, ClientID: "client", ClientSecret: "s3cret",
+		Scopes: []string{"read", "write"}, Params: map[string]string{"audience": "api"},
+	}}
+	assert.NoError(t, webhook.Auth.compile())
+	p := &MultiNotifierPlugin{}
+	ctx := context.Background()
+
+	// The token is cached until shortly before its expiry.
+	assert.NoError(t, p.sendHTTPRequest(ctx, webhook, "a", ""))
+	assert.NoError(t, p.sendHTTPRequest(ctx, webhook, "b", ""))
+	assert.Equal(t, []string{"Bearer token-1", "Bearer token-1"}, authorizations)
+
+	expireIn = 10
+	webhook.Auth.invalidate()
+	assert.Error(t, p.sendHTTPRequest(ctx, webhook, "c", ""))
+	// Rejected tokens are discarded, the expiring token-3 is refreshed right away.
+	assert.NoError(t, p.sendHTTPRequest(ctx, webhook, "d", ""))
+	assert.NoError(t, p.sendHTTPRequest(ctx, webhook, "e", ""))
+	assert.Equal(t, []string{"Bearer token-3", "Bearer token-4"}, authorizations[3:])
+
+	webhook.Auth.ClientSecret = "wrong"
+	webhook.Auth.invalidate()
+	err := p.sendHTTPRequest(ctx, webhook, "f", "")
+	assert.ErrorContains(t, err, "invalid_client")
+	assert.NotContains(t, err.Error(), "wrong")
+}
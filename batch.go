@@ -0,0 +1,441 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const defaultBatchBody = "{{range .messages}}{{.title}}\n{{.message}}\n\n{{end}}"
+
+// batchSaveInterval is how often batches which failed to persist are saved again.
+const batchSaveInterval = 5 * time.Second
+
+// Batch collects the messages of a webhook and delivers them as one digest. A batch is flushed
+// every Interval, once it has MaxSize messages, or once its oldest message is older than MaxAge.
+type Batch struct {
+	Interval Duration `yaml:"interval"`
+	MaxSize  int      `yaml:"max_size"`
+	MaxAge   Duration `yaml:"max_age"`
+	// Body is the template of the digest, `{{range .messages}}` iterates over the messages.
+	Body string `yaml:"body"`
+
+	mu sync.Mutex
+	// saveMu serializes saves. Messages added while a save is in flight are persisted together by
+	// the next one.
+	saveMu  sync.Mutex
+	entries []*BatchEntry
+	notify  chan struct{}
+	// closed is set once the batch was handed over to the config replacing its own.
+	closed bool
+	// dirty is set if the entries changed since they were persisted.
+	dirty bool
+}
+
+// BatchEntry is a message waiting in a batch. The state of the message which isn't part of its
+// JSON encoding is persisted along with it.
+type BatchEntry struct {
+	Message    *MessageExternal `json:"message"`
+	Added      time.Time        `json:"added"`
+	App        *Application     `json:"app,omitempty"`
+	Relay      string           `json:"relay,omitempty"`
+	Duplicates int              `json:"duplicates,omitempty"`
+}
+
+func newBatchEntry(msg *MessageExternal) *BatchEntry {
+	return &BatchEntry{Message: msg, Added: time.Now(), App: msg.app, Relay: msg.relay, Duplicates: msg.duplicates}
+}
+
+// restore sets the state of the message persisted with the entry.
+func (e *BatchEntry) restore() {
+	if e.Message.app == nil {
+		e.Message.app = e.App
+	}
+	if e.Message.relay == "" {
+		e.Message.relay = e.Relay
+	}
+	if e.Message.duplicates == 0 {
+		e.Message.duplicates = e.Duplicates
+	}
+}
+
+func (b *Batch) compile(webhook *WebHook) error {
+	if b == nil {
+		return nil
+	}
+	if b.Interval <= 0 && b.MaxAge <= 0 {
+		return fmt.Errorf("interval or max_age is required")
+	}
+	if b.MaxSize < 0 {
+		return fmt.Errorf("max_size must not be negative")
+	}
+	switch webhook.BodyFormat {
+	case BodyFormatAuto, BodyFormatJSON, BodyFormatText:
+	default:
+		return fmt.Errorf("body_format %q is not supported by batches", webhook.BodyFormat)
+	}
+	if b.Body == "" {
+		b.Body = defaultBatchBody
+	}
+	b.notify = make(chan struct{}, 1)
+	return nil
+}
+
+// add queues the entries, it returns false if the batch is closed.
+func (b *Batch) add(entries ...*BatchEntry) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return false
+	}
+	b.entries = append(b.entries, entries...)
+	b.dirty = true
+	b.signal()
+	return true
+}
+
+// signal wakes up the flush loop. The caller must hold b.mu.
+func (b *Batch) signal() {
+	select {
+	case b.notify <- struct{}{}:
+	default:
+	}
+}
+
+// close takes the queued entries and stops the batch, its flush loop exits. It waits for a save in
+// flight, which could otherwise overwrite the state persisted by the batch replacing this one.
+func (b *Batch) close() []*BatchEntry {
+	b.saveMu.Lock()
+	defer b.saveMu.Unlock()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	entries := b.entries
+	b.entries = nil
+	b.closed = true
+	b.signal()
+	return entries
+}
+
+func (b *Batch) isClosed() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.closed
+}
+
+func (b *Batch) take() []*BatchEntry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	entries := b.entries
+	b.entries = nil
+	b.dirty = b.dirty || len(entries) > 0
+	return entries
+}
+
+func (b *Batch) size() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.entries)
+}
+
+func (b *Batch) full() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.MaxSize > 0 && len(b.entries) >= b.MaxSize
+}
+
+// deadline returns when the oldest message reaches MaxAge, zero if there is none.
+func (b *Batch) deadline() time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.MaxAge <= 0 || len(b.entries) == 0 {
+		return time.Time{}
+	}
+	return b.entries[0].Added.Add(time.Duration(b.MaxAge))
+}
+
+// webhookKey identifies the persisted state of a webhook, by its name or by a hash of the URL as
+// configured. Resolved URLs may contain secrets, so they are never persisted.
+func webhookKey(webhook *WebHook) string {
+	if webhook.key != "" {
+		return webhook.key
+	}
+	if webhook.Name != "" {
+		return webhook.Name
+	}
+	return urlKey(webhook.Url)
+}
+
+// urlKey returns the key of an unnamed webhook with the configured URL.
+func urlKey(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return "url-" + hex.EncodeToString(sum[:6])
+}
+
+// addToBatch queues the message in the webhook's batch and persists it. If the config was
+// reloaded meanwhile, the message is queued in the batch that replaced the closed one, or sent
+// right away if the batch was removed.
+func (p *MultiNotifierPlugin) addToBatch(ctx context.Context, webhook *WebHook, msg *MessageExternal) error {
+	entry := newBatchEntry(msg)
+	for !webhook.Batch.add(entry) {
+		next := findBatchWebHook(p.currentConfig().WebHooks, webhookKey(webhook))
+		if next == nil {
+			return p.sendBatch(ctx, webhook, []*BatchEntry{entry})
+		}
+		webhook = next
+	}
+	if err := p.saveBatch(webhook); err != nil {
+		logger.Error("Failed to persist batch", slog.Any("err", err))
+	}
+	return nil
+}
+
+// findBatchWebHook returns the batch webhook with the key, nil if there is none.
+func findBatchWebHook(webhooks []*WebHook, key string) *WebHook {
+	for _, webhook := range webhooks {
+		if webhook.Batch != nil && webhookKey(webhook) == key {
+			return webhook
+		}
+	}
+	return nil
+}
+
+// saveBatch persists the current messages of the webhook's batch if they changed. Saves are
+// serialized, so concurrent saves can't overwrite a newer state with an older one, and a save
+// waiting for the one in flight finds nothing left to do if that one included its messages.
+func (p *MultiNotifierPlugin) saveBatch(webhook *WebHook) error {
+	b := webhook.Batch
+	b.saveMu.Lock()
+	defer b.saveMu.Unlock()
+
+	b.mu.Lock()
+	// The persisted state of closed batches belongs to the batch replacing them.
+	if b.closed || !b.dirty {
+		b.mu.Unlock()
+		return nil
+	}
+	b.dirty = false
+	entries := append([]*BatchEntry(nil), b.entries...)
+	b.mu.Unlock()
+
+	err := p.updateStorage(func(storage *Storage) {
+		if len(entries) == 0 {
+			delete(storage.Batches, webhookKey(webhook))
+			return
+		}
+		if storage.Batches == nil {
+			storage.Batches = make(map[string][]*BatchEntry)
+		}
+		storage.Batches[webhookKey(webhook)] = entries
+	})
+	if err != nil {
+		b.mu.Lock()
+		b.dirty = true
+		b.mu.Unlock()
+		return fmt.Errorf("failed to persist batch of %s: %w", webhook.Url, err)
+	}
+	return nil
+}
+
+// startBatches restores the persisted batches and flushes them in the background until ctx is done.
+func (p *MultiNotifierPlugin) startBatches(ctx context.Context, webhooks []*WebHook) {
+	storage, err := p.loadStorage()
+	if err != nil {
+		logger.Error("Failed to restore batches", slog.Any("err", err))
+		storage = &Storage{}
+	}
+
+	for _, webhook := range webhooks {
+		if webhook.Batch == nil {
+			continue
+		}
+		// Messages kept in memory since the last Disable are more recent than the persisted ones,
+		// which are only restored after a restart.
+		if entries := storage.Batches[webhookKey(webhook)]; len(entries) > 0 && webhook.Batch.size() == 0 {
+			for _, entry := range entries {
+				entry.restore()
+			}
+			webhook.Batch.add(entries...)
+			logger.Info("Restored batch", slog.String("webhook", webhook.Url), slog.Int("messages", len(entries)))
+		}
+		go p.runBatch(ctx, webhook)
+	}
+}
+
+// handOverBatches closes the batches of the old webhooks and queues their messages in the
+// batches of the same webhooks in the new config. The messages of removed batches are returned.
+func handOverBatches(old, webhooks []*WebHook) map[*WebHook][]*BatchEntry {
+	orphans := make(map[*WebHook][]*BatchEntry)
+	for _, webhook := range old {
+		if webhook.Batch == nil {
+			continue
+		}
+		entries := webhook.Batch.close()
+		if next := findBatchWebHook(webhooks, webhookKey(webhook)); next != nil {
+			next.Batch.add(entries...)
+		} else if len(entries) > 0 {
+			orphans[webhook] = entries
+		}
+	}
+	return orphans
+}
+
+// reloadBatches flushes the batches of the reloaded config in the background until ctx is done.
+// The messages of removed batches are sent with their old webhooks.
+func (p *MultiNotifierPlugin) reloadBatches(ctx context.Context, webhooks []*WebHook, orphans map[*WebHook][]*BatchEntry) {
+	for webhook, entries := range orphans {
+		webhook, entries := webhook, entries
+		key := webhookKey(webhook)
+		err := p.updateStorage(func(storage *Storage) {
+			delete(storage.Batches, key)
+		})
+		if err != nil {
+			logger.Error("Failed to persist batch", slog.Any("err", err))
+		}
+		go func() {
+			if err := p.sendBatch(ctx, webhook, entries); err != nil {
+				logger.Error("Failed to send batch", slog.Any("error", err))
+			}
+		}()
+	}
+
+	for _, webhook := range webhooks {
+		if webhook.Batch == nil {
+			continue
+		}
+		if err := p.saveBatch(webhook); err != nil {
+			logger.Error("Failed to persist batch", slog.Any("err", err))
+		}
+		go p.runBatch(ctx, webhook)
+	}
+}
+
+func (p *MultiNotifierPlugin) runBatch(ctx context.Context, webhook *WebHook) {
+	b := webhook.Batch
+
+	var tick <-chan time.Time
+	if b.Interval > 0 {
+		ticker := time.NewTicker(time.Duration(b.Interval))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	save := time.NewTicker(batchSaveInterval)
+	defer save.Stop()
+
+	for {
+		var (
+			age   <-chan time.Time
+			timer *time.Timer
+		)
+		if deadline := b.deadline(); !deadline.IsZero() {
+			timer = time.NewTimer(time.Until(deadline))
+			age = timer.C
+		}
+
+		flush := false
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			if err := p.saveBatch(webhook); err != nil {
+				logger.Error("Failed to persist batch", slog.Any("err", err))
+			}
+			return
+		case <-save.C:
+			if err := p.saveBatch(webhook); err != nil {
+				logger.Error("Failed to persist batch", slog.Any("err", err))
+			}
+		case <-tick:
+			flush = true
+		case <-age:
+			flush = true
+		case <-b.notify:
+			if b.isClosed() {
+				if timer != nil {
+					timer.Stop()
+				}
+				return
+			}
+			flush = b.full()
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+
+		if flush {
+			if err := p.flushBatch(ctx, webhook); err != nil {
+				logger.Error("Failed to send batch", slog.Any("error", err))
+			}
+		}
+	}
+}
+
+// flushBatch delivers all messages of the webhook's batch as one digest.
+func (p *MultiNotifierPlugin) flushBatch(ctx context.Context, webhook *WebHook) error {
+	entries := webhook.Batch.take()
+	if len(entries) == 0 {
+		return nil
+	}
+	if err := p.saveBatch(webhook); err != nil {
+		logger.Error("Failed to persist batch", slog.Any("err", err))
+	}
+	return p.sendBatch(ctx, webhook, entries)
+}
+
+// sendBatch delivers the messages as one digest.
+func (p *MultiNotifierPlugin) sendBatch(ctx context.Context, webhook *WebHook, entries []*BatchEntry) error {
+	_, span := p.currentTracer().start(ctx, "render message", spanKindInternal)
+	span.setAttr("webhook", webhookLabel(webhook))
+	span.setAttr("webhook.batch.size", len(entries))
+	body, err := p.renderBatchBody(ctx, webhook, entries)
+	span.finish(err)
+	if err != nil {
+		p.stats.failed(webhook, err)
+		p.reportDelivery(webhook, err)
+		return fmt.Errorf("failed to process batch body for %s: %w", webhook.Url, err)
+	}
+
+	msgs := make([]*MessageExternal, 0, len(entries))
+	for _, entry := range entries {
+		msgs = append(msgs, entry.Message)
+	}
+	err = p.sendHTTPRequest(ctx, webhook, body, "", msgs...)
+	if err != nil {
+		return fmt.Errorf("failed to send batch request to %s: %w", webhook.Url, err)
+	}
+
+	return nil
+}
+
+func (p *MultiNotifierPlugin) renderBatchBody(ctx context.Context, webhook *WebHook, entries []*BatchEntry) (string, error) {
+	apps := p.currentApps()
+	messages := make([]map[string]interface{}, 0, len(entries))
+	for _, entry := range entries {
+		msg := entry.Message
+		// Restored messages don't know their application yet.
+		if msg.app == nil && apps != nil {
+			msg.app, _ = apps.get(ctx, msg.ApplicationID)
+		}
+		messages = append(messages, templateData(msg))
+	}
+	data := map[string]interface{}{
+		"messages": messages,
+		"count":    len(messages),
+	}
+
+	switch webhook.BodyFormat {
+	case BodyFormatJSON:
+		return processJSONBody(webhook.Batch.Body, data)
+	case BodyFormatText:
+		return executeTemplate(webhook.Batch.Body, data)
+	default:
+		return processBodyTemplate(webhook.Batch.Body, data)
+	}
+}
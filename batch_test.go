@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchCompile(t *testing.T) {
+	webhook := &WebHook{}
+	assert.Error(t, (&Batch{}).compile(webhook))
+	assert.Error(t, (&Batch{Interval: Duration(time.Minute), MaxSize: -1}).compile(webhook))
+	assert.Error(t, (&Batch{MaxAge: Duration(time.Minute)}).compile(&WebHook{BodyFormat: BodyFormatForm}))
+
+	batch := &Batch{Interval: Duration(time.Minute)}
+	assert.NoError(t, batch.compile(webhook))
+	assert.Equal(t, defaultBatchBody, batch.Body)
+}
+
+func TestBatchFlushOnMaxSize(t *testing.T) {
+	bodies := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- string(body)
+	}))
+	defer server.Close()
+
+	webhook := &WebHook{
+		Url:    server.URL,
+		Method: "POST",
+		Batch: &Batch{
+			Interval: Duration(time.Hour),
+			MaxSize:  2,
+			Body:     `{"text": "{{.count}} messages:{{range .messages}} {{.title}}{{end}}"}`,
+		},
+	}
+	assert.NoError(t, webhook.Batch.compile(webhook))
+
+	storage := &memoryStorage{}
+	plugin := &MultiNotifierPlugin{}
+	plugin.SetStorageHandler(storage)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	plugin.startBatches(ctx, []*WebHook{webhook})
+
+	assert.NoError(t, plugin.forwardMessage(ctx, webhook, &MessageExternal{Title: "first"}))
+	stored, _ := plugin.loadStorage()
+	assert.Len(t, stored.Batches[webhookKey(webhook)], 1)
+
+	assert.NoError(t, plugin.forwardMessage(ctx, webhook, &MessageExternal{Title: "second"}))
+
+	select {
+	case body := <-bodies:
+		assert.Equal(t, `{"text":"2 messages: first second"}`, body)
+	case <-time.After(time.Second):
+		t.Fatal("batch was not flushed")
+	}
+
+	assert.Eventually(t, func() bool {
+		stored, _ := plugin.loadStorage()
+		return len(stored.Batches) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestBatchFlushOnMaxAgeAfterRestore(t *testing.T) {
+	bodies := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- string(body)
+	}))
+	defer server.Close()
+
+	newWebhook := func() *WebHook {
+		webhook := &WebHook{Url: server.URL, Method: "POST", Batch: &Batch{
+			MaxAge: Duration(100 * time.Millisecond),
+			Body:   "{{range .messages}}[{{.app}}] {{.title}}: {{.message}} {{.relay}}{{end}}",
+		}}
+		assert.NoError(t, webhook.Batch.compile(webhook))
+		return webhook
+	}
+
+	storage := &memoryStorage{}
+	plugin := &MultiNotifierPlugin{}
+	plugin.SetStorageHandler(storage)
+
+	// The message is queued but the plugin stops, without being disabled, before the batch is flushed.
+	webhook := newWebhook()
+	msg := &MessageExternal{Title: "kept", Message: "across restarts", app: &Application{Name: "backup"}, relay: `{"hops":1}`}
+	assert.NoError(t, plugin.forwardMessage(context.Background(), webhook, msg))
+
+	restarted := &MultiNotifierPlugin{}
+	restarted.SetStorageHandler(storage)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	restarted.startBatches(ctx, []*WebHook{newWebhook()})
+
+	select {
+	case body := <-bodies:
+		assert.Equal(t, `[backup] kept: across restarts {"hops":1}`, body)
+	case <-time.After(time.Second):
+		t.Fatal("restored batch was not flushed")
+	}
+}
+
+func TestBatchHandOver(t *testing.T) {
+	bodies := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- string(body)
+	}))
+	defer server.Close()
+
+	newWebhook := func(name string) *WebHook {
+		webhook := &WebHook{Name: name, Url: server.URL, Method: "POST", Batch: &Batch{Interval: Duration(time.Hour)}}
+		assert.NoError(t, webhook.Batch.compile(webhook))
+		return webhook
+	}
+	kept, removed := newWebhook("kept"), newWebhook("removed")
+	plugin := &MultiNotifierPlugin{}
+	plugin.SetStorageHandler(&memoryStorage{})
+	ctx := context.Background()
+	assert.NoError(t, plugin.addToBatch(ctx, kept, &MessageExternal{Title: "first"}))
+	assert.NoError(t, plugin.addToBatch(ctx, removed, &MessageExternal{Title: "orphan"}))
+
+	replacement := newWebhook("kept")
+	plugin.config = &Config{WebHooks: []*WebHook{replacement}}
+	orphans := handOverBatches([]*WebHook{kept, removed}, plugin.config.WebHooks)
+	assert.Len(t, orphans[removed], 1)
+	assert.True(t, kept.Batch.isClosed())
+	assert.Equal(t, 1, replacement.Batch.size())
+
+	// Messages forwarded with the old config end up in the new batch, or are sent if it is gone.
+	assert.NoError(t, plugin.addToBatch(ctx, kept, &MessageExternal{Title: "second"}))
+	assert.Equal(t, 2, replacement.Batch.size())
+	assert.NoError(t, plugin.saveBatch(replacement))
+	stored, _ := plugin.loadStorage()
+	assert.Len(t, stored.Batches["kept"], 2)
+
+	assert.NoError(t, plugin.addToBatch(ctx, removed, &MessageExternal{Title: "late"}))
+	assert.Equal(t, "late\n\n\n", <-bodies)
+}
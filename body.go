@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"unicode"
+)
+
+// Supported values of WebHook.BodyFormat.
+const (
+	BodyFormatAuto      = ""
+	BodyFormatJSON      = "json"
+	BodyFormatText      = "text"
+	BodyFormatForm      = "form"
+	BodyFormatMultipart = "multipart"
+	BodyFormatXML       = "xml"
+)
+
+const defaultXMLRoot = "message"
+
+// defaultMaxFileSize limits the size of files downloaded for multipart bodies.
+const defaultMaxFileSize = 10 << 20
+
+// BodyField describes a templated key/value pair of a structured request body.
+type BodyField struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+	// File is a templated URL whose content is attached as a file part, multipart only.
+	File     string `yaml:"file"`
+	Filename string `yaml:"filename"`
+	// MaxSize is the maximum size of the file in bytes, larger files fail the delivery.
+	MaxSize int64 `yaml:"max_size"`
+	// Attrs and Fields describe attributes and child elements, XML only.
+	Attrs  map[string]string `yaml:"attrs"`
+	Fields []*BodyField      `yaml:"fields"`
+}
+
+// defaultContentType returns the Content-Type header used when the webhook doesn't set one.
+// Multipart bodies return an empty string since their boundary is only known after rendering.
+func defaultContentType(format string) string {
+	switch format {
+	case BodyFormatJSON:
+		return "application/json"
+	case BodyFormatForm:
+		return "application/x-www-form-urlencoded"
+	case BodyFormatMultipart:
+		return ""
+	case BodyFormatXML:
+		return "application/xml"
+	default:
+		return "text/plain"
+	}
+}
+
+func validateBodyFormat(webhook *WebHook) error {
+	if webhook.BodyRoot != "" {
+		if webhook.BodyFormat != BodyFormatXML {
+			return fmt.Errorf("body_root is only supported by xml bodies")
+		}
+		if _, err := xmlName(webhook.BodyRoot); err != nil {
+			return fmt.Errorf("invalid body_root: %w", err)
+		}
+	}
+
+	switch webhook.BodyFormat {
+	case BodyFormatAuto, BodyFormatJSON, BodyFormatText:
+		if len(webhook.BodyFields) > 0 {
+			return fmt.Errorf("body_fields are not supported by body_format %q", webhook.BodyFormat)
+		}
+		return nil
+	case BodyFormatForm, BodyFormatMultipart, BodyFormatXML:
+	default:
+		return fmt.Errorf("unsupported body_format %q", webhook.BodyFormat)
+	}
+
+	if webhook.Body != "" {
+		return fmt.Errorf("body can't be used with body_format %q, use body_fields instead", webhook.BodyFormat)
+	}
+	return validateBodyFields(webhook.BodyFormat, webhook.BodyFields)
+}
+
+func validateBodyFields(format string, fields []*BodyField) error {
+	for _, field := range fields {
+		if field.Name == "" {
+			return fmt.Errorf("body field name is required")
+		}
+		if field.File != "" && format != BodyFormatMultipart {
+			return fmt.Errorf("body field %s: file is only supported by multipart bodies", field.Name)
+		}
+		if field.MaxSize < 0 {
+			return fmt.Errorf("body field %s: max_size must not be negative", field.Name)
+		}
+		if (len(field.Attrs) > 0 || len(field.Fields) > 0) && format != BodyFormatXML {
+			return fmt.Errorf("body field %s: attrs and fields are only supported by xml bodies", field.Name)
+		}
+		if format == BodyFormatXML {
+			if _, err := xmlName(field.Name); err != nil {
+				return err
+			}
+			for attr := range field.Attrs {
+				if !isXMLName(attr) {
+					return fmt.Errorf("body field %s: invalid xml attribute name %q", field.Name, attr)
+				}
+			}
+			if err := validateBodyFields(format, field.Fields); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// renderWebhookBody renders the request body of the webhook for the message. The returned
+// content type is non-empty only if it has to override the configured Content-Type header.
+func (p *MultiNotifierPlugin) renderWebhookBody(ctx context.Context, webhook *WebHook, msg *MessageExternal) (body string, contentType string, err error) {
+	switch webhook.BodyFormat {
+	case BodyFormatJSON:
+		body, err = processJSONBody(webhook.Body, templateData(msg))
+	case BodyFormatText:
+		body, err = processTemplateString(webhook.Body, msg)
+	case BodyFormatForm:
+		body, err = processFormBody(webhook.BodyFields, msg)
+	case BodyFormatMultipart:
+		body, contentType, err = processMultipartBody(ctx, webhook.BodyFields, msg)
+	case BodyFormatXML:
+		body, err = processXMLBody(webhook.BodyRoot, webhook.BodyFields, msg)
+	default:
+		body, err = p.processWebhookBody(webhook.Body, msg)
+	}
+	return body, contentType, err
+}
+
+func processJSONBody(body string, data map[string]interface{}) (string, error) {
+	var jsonBody interface{}
+	if err := json.Unmarshal([]byte(body), &jsonBody); err != nil {
+		return "", fmt.Errorf("body is not valid JSON: %w", err)
+	}
+
+	switch v := jsonBody.(type) {
+	case map[string]interface{}:
+		if err := processJSONTemplate(v, data); err != nil {
+			return "", fmt.Errorf("failed to process JSON body: %w", err)
+		}
+	case []interface{}:
+		if err := processJSONTemplate(map[string]interface{}{"": v}, data); err != nil {
+			return "", fmt.Errorf("failed to process JSON body: %w", err)
+		}
+	case string:
+		s, err := executeTemplate(v, data)
+		if err != nil {
+			return "", err
+		}
+		jsonBody = s
+	}
+
+	newBody, err := json.Marshal(jsonBody)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal body: %w", err)
+	}
+	return string(newBody), nil
+}
+
+func processFormBody(fields []*BodyField, msg *MessageExternal) (string, error) {
+	values := url.Values{}
+	for _, field := range fields {
+		value, err := processTemplateString(field.Value, msg)
+		if err != nil {
+			return "", fmt.Errorf("failed to process form field %s: %w", field.Name, err)
+		}
+		values.Add(field.Name, value)
+	}
+	return values.Encode(), nil
+}
+
+func processMultipartBody(ctx context.Context, fields []*BodyField, msg *MessageExternal) (string, string, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+
+	for _, field := range fields {
+		if field.File == "" {
+			value, err := processTemplateString(field.Value, msg)
+			if err != nil {
+				return "", "", fmt.Errorf("failed to process multipart field %s: %w", field.Name, err)
+			}
+			if err := w.WriteField(field.Name, value); err != nil {
+				return "", "", fmt.Errorf("failed to write multipart field %s: %w", field.Name, err)
+			}
+			continue
+		}
+
+		fileURL, err := processTemplateString(field.File, msg)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to process multipart file %s: %w", field.Name, err)
+		}
+		// Messages without an image simply don't get the file part.
+		if fileURL == "" {
+			continue
+		}
+		filename, err := processTemplateString(field.Filename, msg)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to process multipart filename %s: %w", field.Name, err)
+		}
+		if filename == "" {
+			filename = fileNameFromURL(fileURL)
+		}
+
+		part, err := w.CreateFormFile(field.Name, filename)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to create multipart file %s: %w", field.Name, err)
+		}
+		maxSize := field.MaxSize
+		if maxSize == 0 {
+			maxSize = defaultMaxFileSize
+		}
+		if err := downloadFile(ctx, fileURL, maxSize, part); err != nil {
+			return "", "", fmt.Errorf("failed to attach multipart file %s: %w", field.Name, err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return "", "", fmt.Errorf("failed to close multipart body: %w", err)
+	}
+	return buf.String(), w.FormDataContentType(), nil
+}
+
+func fileNameFromURL(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil {
+		if name := path.Base(u.Path); name != "" && name != "." && name != "/" {
+			return name
+		}
+	}
+	return "file"
+}
+
+// downloadFile copies the file at fileURL to w, it fails if the file is larger than maxSize bytes.
+func downloadFile(ctx context.Context, fileURL string, maxSize int64, w io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	res, err := webhookClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", fileURL, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("failed to download %s: unexpected status code: %d", fileURL, res.StatusCode)
+	}
+
+	n, err := io.Copy(w, io.LimitReader(res.Body, maxSize+1))
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", fileURL, err)
+	}
+	if n > maxSize {
+		return fmt.Errorf("failed to download %s: file is larger than %d bytes", fileURL, maxSize)
+	}
+	return nil
+}
+
+func processXMLBody(root string, fields []*BodyField, msg *MessageExternal) (string, error) {
+	if root == "" {
+		root = defaultXMLRoot
+	}
+	rootName, err := xmlName(root)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+
+	if err := enc.EncodeToken(xml.StartElement{Name: rootName}); err != nil {
+		return "", fmt.Errorf("failed to encode xml: %w", err)
+	}
+	if err := encodeXMLFields(enc, fields, msg); err != nil {
+		return "", err
+	}
+	if err := enc.EncodeToken(xml.EndElement{Name: rootName}); err != nil {
+		return "", fmt.Errorf("failed to encode xml: %w", err)
+	}
+	if err := enc.Flush(); err != nil {
+		return "", fmt.Errorf("failed to encode xml: %w", err)
+	}
+
+	return buf.String(), nil
+}
+
+func encodeXMLFields(enc *xml.Encoder, fields []*BodyField, msg *MessageExternal) error {
+	for _, field := range fields {
+		name, err := xmlName(field.Name)
+		if err != nil {
+			return err
+		}
+
+		start := xml.StartElement{Name: name}
+		for k, v := range field.Attrs {
+			value, err := processTemplateString(v, msg)
+			if err != nil {
+				return fmt.Errorf("failed to process xml attribute %s of %s: %w", k, field.Name, err)
+			}
+			start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: k}, Value: value})
+		}
+		// Attributes are configured as a map, keep them in a stable order.
+		sort.Slice(start.Attr, func(i, j int) bool { return start.Attr[i].Name.Local < start.Attr[j].Name.Local })
+
+		value, err := processTemplateString(field.Value, msg)
+		if err != nil {
+			return fmt.Errorf("failed to process xml element %s: %w", field.Name, err)
+		}
+
+		if err := enc.EncodeToken(start); err != nil {
+			return fmt.Errorf("failed to encode xml element %s: %w", field.Name, err)
+		}
+		if value != "" {
+			if err := enc.EncodeToken(xml.CharData(value)); err != nil {
+				return fmt.Errorf("failed to encode xml element %s: %w", field.Name, err)
+			}
+		}
+		if err := encodeXMLFields(enc, field.Fields, msg); err != nil {
+			return err
+		}
+		if err := enc.EncodeToken(start.End()); err != nil {
+			return fmt.Errorf("failed to encode xml element %s: %w", field.Name, err)
+		}
+	}
+	return nil
+}
+
+func xmlName(name string) (xml.Name, error) {
+	if !isXMLName(name) {
+		return xml.Name{}, fmt.Errorf("invalid xml element name %q", name)
+	}
+	return xml.Name{Local: name}, nil
+}
+
+// isXMLName reports whether name is a valid XML name without a namespace prefix: a letter or _
+// followed by letters, digits, -, _ and .
+func isXMLName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case unicode.IsLetter(r) || r == '_':
+		case i > 0 && (unicode.IsDigit(r) || r == '-' || r == '.'):
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateBodyFormat(t *testing.T) {
+	testCases := []struct {
+		name        string
+		webhook     *WebHook
+		expectError bool
+	}{
+		{
+			name:    "Plain body",
+			webhook: &WebHook{Body: "{{.message}}"},
+		},
+		{
+			name:        "Unknown format",
+			webhook:     &WebHook{BodyFormat: "yaml"},
+			expectError: true,
+		},
+		{
+			name:        "Fields without structured format",
+			webhook:     &WebHook{BodyFields: []*BodyField{{Name: "text"}}},
+			expectError: true,
+		},
+		{
+			name:        "Body with structured format",
+			webhook:     &WebHook{BodyFormat: BodyFormatForm, Body: "{{.message}}"},
+			expectError: true,
+		},
+		{
+			name:        "File in form body",
+			webhook:     &WebHook{BodyFormat: BodyFormatForm, BodyFields: []*BodyField{{Name: "image", File: "{{.image}}"}}},
+			expectError: true,
+		},
+		{
+			name:        "Invalid xml element name",
+			webhook:     &WebHook{BodyFormat: BodyFormatXML, BodyFields: []*BodyField{{Name: "a b"}}},
+			expectError: true,
+		},
+		{
+			name:        "Invalid xml attribute name",
+			webhook:     &WebHook{BodyFormat: BodyFormatXML, BodyFields: []*BodyField{{Name: "item", Attrs: map[string]string{`a="1" b`: "2"}}}},
+			expectError: true,
+		},
+		{
+			name:        "Xml element name starting with a digit",
+			webhook:     &WebHook{BodyFormat: BodyFormatXML, BodyFields: []*BodyField{{Name: "1st"}}},
+			expectError: true,
+		},
+		{
+			name:        "Invalid xml root name",
+			webhook:     &WebHook{BodyFormat: BodyFormatXML, BodyRoot: "<alert>"},
+			expectError: true,
+		},
+		{
+			name:        "Root of a json body",
+			webhook:     &WebHook{BodyFormat: BodyFormatJSON, Body: "{}", BodyRoot: "alert"},
+			expectError: true,
+		},
+		{
+			name:    "Xml root name",
+			webhook: &WebHook{BodyFormat: BodyFormatXML, BodyRoot: "alert"},
+		},
+		{
+			name:        "Negative file size",
+			webhook:     &WebHook{BodyFormat: BodyFormatMultipart, BodyFields: []*BodyField{{Name: "photo", File: "{{.image}}", MaxSize: -1}}},
+			expectError: true,
+		},
+		{
+			name: "Nested xml elements",
+			webhook: &WebHook{BodyFormat: BodyFormatXML, BodyFields: []*BodyField{
+				{Name: "item", Attrs: map[string]string{"id": "1"}, Fields: []*BodyField{{Name: "title"}}},
+			}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateBodyFormat(tc.webhook)
+			if tc.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestProcessFormBody(t *testing.T) {
+	msg := &MessageExternal{Title: "Disk & CPU", Message: "usage=95%"}
+	body, err := processFormBody([]*BodyField{
+		{Name: "subject", Value: "{{.title}}"},
+		{Name: "text", Value: "{{.message}}"},
+	}, msg)
+	assert.NoError(t, err)
+
+	values, err := url.ParseQuery(body)
+	assert.NoError(t, err)
+	assert.Equal(t, "Disk & CPU", values.Get("subject"))
+	assert.Equal(t, "usage=95%", values.Get("text"))
+}
+
+func TestProcessXMLBody(t *testing.T) {
+	msg := &MessageExternal{Title: "<b>Alert</b>", Message: "a & b"}
+	body, err := processXMLBody("notification", []*BodyField{
+		{Name: "title", Value: "{{.title}}", Attrs: map[string]string{"lang": "en", "format": "\"plain\""}},
+		{Name: "body", Fields: []*BodyField{{Name: "text", Value: "{{.message}}"}}},
+	}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>`+"\n"+
+		`<notification><title format="&#34;plain&#34;" lang="en">&lt;b&gt;Alert&lt;/b&gt;</title><body><text>a &amp; b</text></body></notification>`, body)
+}
+
+func TestProcessMultipartBody(t *testing.T) {
+	image := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("PNGDATA"))
+	}))
+	defer image.Close()
+
+	msg := &MessageExternal{
+		Title:   "Camera",
+		Message: "Motion detected",
+		Extras: map[string]interface{}{
+			"client::notification": map[string]interface{}{"bigImageUrl": image.URL + "/snapshot.png"},
+		},
+	}
+	body, contentType, err := processMultipartBody(context.Background(), []*BodyField{
+		{Name: "caption", Value: "{{.title}}: {{.message}}"},
+		{Name: "photo", File: "{{.image}}"},
+	}, msg)
+	assert.NoError(t, err)
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	assert.NoError(t, err)
+	assert.Equal(t, "multipart/form-data", mediaType)
+
+	r := multipart.NewReader(strings.NewReader(body), params["boundary"])
+	part, err := r.NextPart()
+	assert.NoError(t, err)
+	assert.Equal(t, "caption", part.FormName())
+	data, _ := io.ReadAll(part)
+	assert.Equal(t, "Camera: Motion detected", string(data))
+
+	part, err = r.NextPart()
+	assert.NoError(t, err)
+	assert.Equal(t, "photo", part.FormName())
+	assert.Equal(t, "snapshot.png", part.FileName())
+	data, _ = io.ReadAll(part)
+	assert.Equal(t, "PNGDATA", string(data))
+
+	// Without an image the file part is skipped.
+	msg.Extras = nil
+	body, _, err = processMultipartBody(context.Background(), []*BodyField{{Name: "photo", File: "{{.image}}"}}, msg)
+	assert.NoError(t, err)
+	assert.NotContains(t, body, "photo")
+
+	// Files larger than max_size fail the delivery.
+	msg.Extras = map[string]interface{}{"client::notification": map[string]interface{}{"bigImageUrl": image.URL + "/snapshot.png"}}
+	_, _, err = processMultipartBody(context.Background(), []*BodyField{{Name: "photo", File: "{{.image}}", MaxSize: 7}}, msg)
+	assert.NoError(t, err)
+	_, _, err = processMultipartBody(context.Background(), []*BodyField{{Name: "photo", File: "{{.image}}", MaxSize: 6}}, msg)
+	assert.ErrorContains(t, err, "larger than 6 bytes")
+}
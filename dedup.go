@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const defaultDedupWindow = time.Minute
+
+// defaultDedupMaxWindowFactor limits windows extended by duplicates to this many times the window.
+const defaultDedupMaxWindowFactor = 10
+
+// Dedup suppresses duplicate messages of a webhook. Messages are duplicates if their rendered Key
+// is equal, the window slides so a message is suppressed as long as its last copy is more recent,
+// but at most for MaxWindow after the first copy.
+type Dedup struct {
+	// Key is a template identifying duplicates, by default the application, title and message.
+	Key    string   `yaml:"key"`
+	Window Duration `yaml:"window"`
+	// MaxWindow closes windows kept open by duplicates, by default ten times the window.
+	MaxWindow Duration `yaml:"max_window"`
+	// Summary sends the last duplicate with the number of suppressed copies once the window closes.
+	Summary bool `yaml:"summary"`
+
+	mu      sync.Mutex
+	entries map[string]*dedupEntry
+}
+
+type dedupEntry struct {
+	timer      *time.Timer
+	first      time.Time
+	suppressed int
+	last       *MessageExternal
+}
+
+func (d *Dedup) compile() error {
+	if d == nil {
+		return nil
+	}
+	if d.Window < 0 {
+		return fmt.Errorf("window must not be negative")
+	}
+	if d.Window == 0 {
+		d.Window = Duration(defaultDedupWindow)
+	}
+	if d.MaxWindow < 0 {
+		return fmt.Errorf("max_window must not be negative")
+	}
+	if d.MaxWindow == 0 {
+		d.MaxWindow = d.Window * defaultDedupMaxWindowFactor
+	}
+	if d.MaxWindow < d.Window {
+		return fmt.Errorf("max_window must not be shorter than the window")
+	}
+	if d.Key != "" {
+		if _, err := template.New("").Parse(d.Key); err != nil {
+			return fmt.Errorf("invalid key: %w", err)
+		}
+	}
+	return nil
+}
+
+func (d *Dedup) key(msg *MessageExternal) (string, error) {
+	if d.Key == "" {
+		return fmt.Sprintf("%d\x00%s\x00%s", msg.ApplicationID, msg.Title, msg.Message), nil
+	}
+	return processTemplateString(d.Key, msg)
+}
+
+// suppress reports whether the message is a duplicate. The first message of a key opens the window,
+// each duplicate extends it up to MaxWindow after the first. onClose is called with the last
+// duplicate and the number of suppressed copies when it closes.
+func (d *Dedup) suppress(msg *MessageExternal, onClose func(last *MessageExternal, suppressed int)) (bool, error) {
+	key, err := d.key(msg)
+	if err != nil {
+		return false, err
+	}
+	window := time.Duration(d.Window)
+	now := time.Now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if entry, ok := d.entries[key]; ok {
+		entry.suppressed++
+		entry.last = msg
+		// A flapping source must not keep the window open forever.
+		if remaining := entry.first.Add(time.Duration(d.MaxWindow)).Sub(now); remaining < window {
+			window = remaining
+		}
+		entry.timer.Reset(window)
+		return true, nil
+	}
+
+	if d.entries == nil {
+		d.entries = make(map[string]*dedupEntry)
+	}
+	entry := &dedupEntry{first: now}
+	entry.timer = time.AfterFunc(window, func() {
+		d.mu.Lock()
+		// The entry may have been replaced if the timer fired while being reset.
+		if d.entries[key] != entry {
+			d.mu.Unlock()
+			return
+		}
+		delete(d.entries, key)
+		last, suppressed := entry.last, entry.suppressed
+		d.mu.Unlock()
+
+		if suppressed > 0 {
+			onClose(last, suppressed)
+		}
+	})
+	d.entries[key] = entry
+
+	return false, nil
+}
+
+// stop discards all open windows without sending summaries.
+func (d *Dedup) stop() {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key, entry := range d.entries {
+		entry.timer.Stop()
+		delete(d.entries, key)
+	}
+}
+
+// deduplicate reports whether the message is a duplicate and must not be delivered to the webhook.
+func (p *MultiNotifierPlugin) deduplicate(ctx context.Context, webhook *WebHook, msg *MessageExternal) (bool, error) {
+	dedup := webhook.Dedup
+	if dedup == nil {
+		return false, nil
+	}
+
+	suppressed, err := dedup.suppress(msg, func(last *MessageExternal, suppressed int) {
+		if !dedup.Summary || ctx.Err() != nil {
+			return
+		}
+
+		summary := *last
+		summary.duplicates = suppressed
+		summary.Message = fmt.Sprintf("%s\n\n(suppressed %d duplicates)", last.Message, suppressed)
+		if err := p.deliverMessage(ctx, webhook, &summary); err != nil {
+			logger.Error("Failed to send duplicates summary", slog.Any("error", err))
+		}
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to process dedup key for %s: %w", webhook.Url, err)
+	}
+	if suppressed {
+		logger.Debug("Suppressed duplicate message", slog.String("webhook", webhook.Url), slog.Uint64("id", uint64(msg.ID)))
+	}
+
+	return suppressed, nil
+}
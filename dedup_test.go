@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedupSuppress(t *testing.T) {
+	dedup := &Dedup{Window: Duration(50 * time.Millisecond)}
+	assert.NoError(t, dedup.compile())
+
+	closed := make(chan int, 1)
+	onClose := func(last *MessageExternal, suppressed int) {
+		assert.Equal(t, uint(3), last.ID)
+		closed <- suppressed
+	}
+
+	msg := func(id uint, title string) *MessageExternal {
+		return &MessageExternal{ID: id, ApplicationID: 1, Title: title, Message: "down"}
+	}
+
+	suppressed, err := dedup.suppress(msg(1, "web-1"), onClose)
+	assert.NoError(t, err)
+	assert.False(t, suppressed)
+
+	suppressed, _ = dedup.suppress(msg(2, "web-1"), onClose)
+	assert.True(t, suppressed)
+	suppressed, _ = dedup.suppress(msg(3, "web-1"), onClose)
+	assert.True(t, suppressed)
+
+	// A different title is not a duplicate.
+	suppressed, _ = dedup.suppress(msg(4, "web-2"), onClose)
+	assert.False(t, suppressed)
+
+	select {
+	case n := <-closed:
+		assert.Equal(t, 2, n)
+	case <-time.After(time.Second):
+		t.Fatal("window was not closed")
+	}
+
+	// After the window closed the message is forwarded again.
+	suppressed, _ = dedup.suppress(msg(5, "web-1"), onClose)
+	assert.False(t, suppressed)
+	dedup.stop()
+}
+
+func TestDedupKeyTemplate(t *testing.T) {
+	dedup := &Dedup{Key: "{{.title}}"}
+	assert.NoError(t, dedup.compile())
+	assert.Equal(t, Duration(defaultDedupWindow), dedup.Window)
+
+	suppressed, _ := dedup.suppress(&MessageExternal{Title: "disk", Message: "90%"}, nil)
+	assert.False(t, suppressed)
+	suppressed, _ = dedup.suppress(&MessageExternal{Title: "disk", Message: "95%"}, nil)
+	assert.True(t, suppressed)
+	dedup.stop()
+
+	assert.Error(t, (&Dedup{Key: "{{.title"}).compile())
+}
+
+func TestForwardMessageDedupSummary(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		bodies []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	webhook := &WebHook{
+		Url:    server.URL,
+		Method: "POST",
+		Body:   "{{.title}}: {{.message}} ({{.duplicates}})",
+		Dedup:  &Dedup{Window: Duration(50 * time.Millisecond), Summary: true},
+	}
+	assert.NoError(t, webhook.Dedup.compile())
+
+	plugin := &MultiNotifierPlugin{}
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, plugin.forwardMessage(context.Background(), webhook, &MessageExternal{Title: "web-1", Message: "down"}))
+	}
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(bodies) == 2
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "web-1: down (0)", bodies[0])
+	assert.Equal(t, "web-1: down\n\n(suppressed 2 duplicates) (2)", bodies[1])
+}
+
+func TestDedupMaxWindow(t *testing.T) {
+	dedup := &Dedup{Window: Duration(40 * time.Millisecond), MaxWindow: Duration(100 * time.Millisecond)}
+	assert.NoError(t, dedup.compile())
+	defer dedup.stop()
+
+	closed := make(chan int, 1)
+	onClose := func(last *MessageExternal, suppressed int) { closed <- suppressed }
+	msg := &MessageExternal{Title: "flapping"}
+
+	// Duplicates keep arriving within the window, still it closes after max_window.
+	suppressed, _ := dedup.suppress(msg, onClose)
+	assert.False(t, suppressed)
+	suppressed, _ = dedup.suppress(msg, onClose)
+	assert.True(t, suppressed)
+	start := time.Now()
+	for suppressed && time.Since(start) < time.Second {
+		time.Sleep(10 * time.Millisecond)
+		suppressed, _ = dedup.suppress(msg, onClose)
+	}
+	assert.False(t, suppressed, "window was not closed")
+	assert.Greater(t, <-closed, 0)
+	assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+}
+
+func TestDedupCompile(t *testing.T) {
+	dedup := &Dedup{Window: Duration(time.Minute)}
+	assert.NoError(t, dedup.compile())
+	assert.Equal(t, Duration(10*time.Minute), dedup.MaxWindow)
+
+	assert.Error(t, (&Dedup{Window: Duration(-time.Minute)}).compile())
+	assert.Error(t, (&Dedup{Window: Duration(time.Hour), MaxWindow: Duration(time.Minute)}).compile())
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const defaultEscalationExpire = 24 * time.Hour
+
+// Escalation is a policy escalating recurring or unresolved alerts to further webhooks.
+type Escalation struct {
+	Name string `yaml:"name"`
+	// When selects the alerts the policy applies to.
+	When string `yaml:"when"`
+	// Resolve matches messages resolving an alert with the same key, which stops its escalation.
+	Resolve string `yaml:"resolve"`
+	// Key is a template identifying an alert, by default the application and title.
+	Key   string            `yaml:"key"`
+	Steps []*EscalationStep `yaml:"steps"`
+	// Expire is how long an alert is tracked after its last step, to not escalate it again.
+	Expire Duration `yaml:"expire"`
+
+	when, resolve *Expression
+}
+
+// EscalationStep sends the alert to a webhook after Delay, or earlier once it occurred Repeats
+// times.
+type EscalationStep struct {
+	Webhook string   `yaml:"webhook"`
+	Delay   Duration `yaml:"delay"`
+	Repeats int      `yaml:"repeats"`
+}
+
+// EscalationState is the persisted state of an escalating alert.
+type EscalationState struct {
+	Policy      string           `json:"policy"`
+	Key         string           `json:"key"`
+	Message     *MessageExternal `json:"message"`
+	Started     time.Time        `json:"started"`
+	Occurrences int              `json:"occurrences"`
+	// Step is the index of the next step.
+	Step int `json:"step"`
+}
+
+func (e *Escalation) compile(webhooks []*WebHook) (err error) {
+	if e.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if e.When == "" {
+		return fmt.Errorf("when is required")
+	}
+	if e.when, err = CompileExpression(e.When); err != nil {
+		return fmt.Errorf("invalid when expression: %w", err)
+	}
+	e.resolve = nil
+	if e.Resolve != "" {
+		if e.resolve, err = CompileExpression(e.Resolve); err != nil {
+			return fmt.Errorf("invalid resolve expression: %w", err)
+		}
+	}
+	if e.Key != "" {
+		if _, err := template.New("").Parse(e.Key); err != nil {
+			return fmt.Errorf("invalid key: %w", err)
+		}
+	}
+	if e.Expire <= 0 {
+		e.Expire = Duration(defaultEscalationExpire)
+	}
+
+	if len(e.Steps) == 0 {
+		return fmt.Errorf("at least one step is required")
+	}
+	for i, step := range e.Steps {
+		if findWebHook(webhooks, step.Webhook) == nil {
+			return fmt.Errorf("step %d: unknown webhook %q", i+1, step.Webhook)
+		}
+		if i > 0 && step.Delay < e.Steps[i-1].Delay {
+			return fmt.Errorf("step %d: delay is shorter than the one of the previous step", i+1)
+		}
+	}
+	return nil
+}
+
+func (e *Escalation) key(msg *MessageExternal) (string, error) {
+	if e.Key == "" {
+		return fmt.Sprintf("%d\x00%s", msg.ApplicationID, msg.Title), nil
+	}
+	return processTemplateString(e.Key, msg)
+}
+
+// findWebHook returns the webhook with the given name.
+func findWebHook(webhooks []*WebHook, name string) *WebHook {
+	if name == "" {
+		return nil
+	}
+	for _, webhook := range webhooks {
+		if webhook.Name == name {
+			return webhook
+		}
+	}
+	return nil
+}
+
+// escalator tracks escalating alerts and fires their steps.
+type escalator struct {
+	ctx      context.Context
+	plugin   *MultiNotifierPlugin
+	policies []*Escalation
+	webhooks []*WebHook
+
+	mu      sync.Mutex
+	states  map[string]*EscalationState
+	timers  map[string]*time.Timer
+	stopped bool
+	done    chan struct{}
+	// dirty is set if the alerts changed since they were persisted.
+	dirty bool
+
+	// flushMu serializes saving the alerts, so an older state can't overwrite a newer one.
+	flushMu sync.Mutex
+}
+
+func escalationID(policy, key string) string {
+	return policy + "\x00" + key
+}
+
+// startEscalations restores the persisted alerts and tracks new ones until ctx is done. It
+// replaces the escalations of the previous config, which take their alerts along via the storage.
+func (p *MultiNotifierPlugin) startEscalations(ctx context.Context, config *Config) {
+	e := &escalator{
+		ctx:      ctx,
+		plugin:   p,
+		policies: config.Escalations,
+		webhooks: config.WebHooks,
+		states:   make(map[string]*EscalationState),
+		timers:   make(map[string]*time.Timer),
+		done:     make(chan struct{}),
+	}
+
+	// Messages are processed while holding the read lock, so none is missed between the stop of
+	// the previous escalations and the restore.
+	p.configMu.Lock()
+	defer p.configMu.Unlock()
+	p.escalator.stop()
+
+	storage, err := p.loadStorage()
+	if err != nil {
+		logger.Error("Failed to restore escalations", slog.Any("err", err))
+		storage = &Storage{}
+	}
+
+	e.mu.Lock()
+	for id, state := range storage.Escalations {
+		// Alerts of removed policies are dropped.
+		if e.policy(state.Policy) == nil {
+			continue
+		}
+		e.states[id] = state
+		e.schedule(id, state)
+	}
+	e.dirty = len(e.states) != len(storage.Escalations)
+	e.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(statsFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				e.stop()
+				return
+			case <-e.done:
+				return
+			case <-ticker.C:
+				e.flush()
+			}
+		}
+	}()
+
+	p.escalator = e
+}
+
+// stop stops tracking alerts and persists them, steps already fired are still delivered.
+func (e *escalator) stop() {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	if !e.stopped {
+		e.stopped = true
+		close(e.done)
+		for id, timer := range e.timers {
+			timer.Stop()
+			delete(e.timers, id)
+		}
+	}
+	e.mu.Unlock()
+
+	// Escalations replacing these restore the alerts from the storage once stop returned.
+	e.flush()
+}
+
+// processEscalations lets the escalations of the active config process the message.
+func (p *MultiNotifierPlugin) processEscalations(msg *MessageExternal) []error {
+	p.configMu.RLock()
+	defer p.configMu.RUnlock()
+	if p.escalator == nil {
+		return nil
+	}
+	return p.escalator.process(msg)
+}
+
+func (e *escalator) policy(name string) *Escalation {
+	for _, policy := range e.policies {
+		if policy.Name == name {
+			return policy
+		}
+	}
+	return nil
+}
+
+// process starts, advances or resolves the escalations of the message.
+func (e *escalator) process(msg *MessageExternal) []error {
+	var (
+		errs    []error
+		changed bool
+	)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.stopped {
+		return nil
+	}
+	for _, policy := range e.policies {
+		key, err := policy.key(msg)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to process key of escalation %s: %w", policy.Name, err))
+			continue
+		}
+		id := escalationID(policy.Name, key)
+
+		if policy.resolve != nil {
+			resolved, err := policy.resolve.Match(msg)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to evaluate resolve expression of escalation %s: %w", policy.Name, err))
+			} else if resolved {
+				if _, ok := e.states[id]; ok {
+					logger.Info("Alert resolved, stopping escalation", slog.String("escalation", policy.Name), slog.String("key", key))
+					e.remove(id)
+					changed = true
+				}
+				continue
+			}
+		}
+
+		matched, err := policy.when.Match(msg)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to evaluate when expression of escalation %s: %w", policy.Name, err))
+			continue
+		}
+		if !matched {
+			continue
+		}
+
+		state, ok := e.states[id]
+		if !ok {
+			state = &EscalationState{Policy: policy.Name, Key: key, Started: time.Now()}
+			e.states[id] = state
+		}
+		state.Message = msg
+		state.Occurrences++
+		e.schedule(id, state)
+		changed = true
+	}
+
+	if changed {
+		e.dirty = true
+	}
+	return errs
+}
+
+// schedule arms the timer of the alert's next step, or of its expiry after the last step.
+// The caller must hold e.mu.
+func (e *escalator) schedule(id string, state *EscalationState) {
+	if timer, ok := e.timers[id]; ok {
+		timer.Stop()
+	}
+
+	policy := e.policy(state.Policy)
+	var at time.Time
+	if state.Step < len(policy.Steps) {
+		step := policy.Steps[state.Step]
+		at = state.Started.Add(time.Duration(step.Delay))
+		if step.Repeats > 0 && state.Occurrences >= step.Repeats {
+			at = time.Now()
+		}
+	} else {
+		at = state.Started.Add(time.Duration(policy.Steps[len(policy.Steps)-1].Delay) + time.Duration(policy.Expire))
+	}
+
+	e.timers[id] = time.AfterFunc(time.Until(at), func() {
+		e.fire(id, state)
+	})
+}
+
+func (e *escalator) fire(id string, state *EscalationState) {
+	if e.ctx.Err() != nil {
+		return
+	}
+
+	e.mu.Lock()
+	// The alert may have been resolved or restarted in the meantime.
+	if e.stopped || e.states[id] != state {
+		e.mu.Unlock()
+		return
+	}
+	policy := e.policy(state.Policy)
+	if state.Step >= len(policy.Steps) {
+		e.remove(id)
+		e.dirty = true
+		e.mu.Unlock()
+		return
+	}
+	step := policy.Steps[state.Step]
+	state.Step++
+	msg := state.Message
+	e.schedule(id, state)
+	e.dirty = true
+	e.mu.Unlock()
+
+	webhook := findWebHook(e.webhooks, step.Webhook)
+	// Restored alerts don't know their application yet.
+	if apps := e.plugin.currentApps(); msg.app == nil && apps != nil {
+		msg.app, _ = apps.get(e.ctx, msg.ApplicationID)
+	}
+	logger.Info("Escalating alert", slog.String("escalation", policy.Name), slog.String("key", state.Key), slog.String("webhook", step.Webhook))
+	if err := e.plugin.deliverMessage(e.ctx, webhook, msg); err != nil {
+		logger.Error("Failed to escalate alert", slog.String("escalation", policy.Name), slog.Any("error", err))
+	}
+}
+
+// remove stops tracking the alert. The caller must hold e.mu.
+func (e *escalator) remove(id string) {
+	if timer, ok := e.timers[id]; ok {
+		timer.Stop()
+		delete(e.timers, id)
+	}
+	delete(e.states, id)
+}
+
+// flush persists the tracked alerts if they changed. The storage is written without holding e.mu,
+// so messages and escalation steps don't wait for it.
+func (e *escalator) flush() {
+	e.flushMu.Lock()
+	defer e.flushMu.Unlock()
+
+	e.mu.Lock()
+	if !e.dirty {
+		e.mu.Unlock()
+		return
+	}
+	e.dirty = false
+	states := make(map[string]*EscalationState, len(e.states))
+	for id, state := range e.states {
+		copied := *state
+		states[id] = &copied
+	}
+	e.mu.Unlock()
+
+	err := e.plugin.updateStorage(func(storage *Storage) {
+		storage.Escalations = states
+	})
+	if err != nil {
+		logger.Error("Failed to persist escalations", slog.Any("err", err))
+		e.mu.Lock()
+		e.dirty = true
+		e.mu.Unlock()
+	}
+}
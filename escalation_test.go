@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newEscalationConfig returns a config escalating priority 8 alerts from chat to pager.
+func newEscalationConfig(t *testing.T, chat, pager string, pagerStep *EscalationStep) *Config {
+	pagerStep.Webhook = "pager"
+	config := &Config{
+		HostServer: "ws://localhost",
+		WebHooks: []*WebHook{
+			{Name: "chat", Url: chat, Body: "chat: {{.title}}", EscalationOnly: true},
+			{Name: "pager", Url: pager, Body: "pager: {{.title}}", EscalationOnly: true},
+		},
+		Escalations: []*Escalation{
+			{
+				Name:    "critical",
+				When:    "priority >= 8",
+				Resolve: `title startsWith "[RESOLVED] "`,
+				Key:     `{{index .extras "alert"}}`,
+				Steps:   []*EscalationStep{{Webhook: "chat"}, pagerStep},
+			},
+		},
+	}
+	plugin := &MultiNotifierPlugin{}
+	assert.NoError(t, plugin.ValidateAndSetConfig(config))
+	return plugin.config
+}
+
+func TestEscalationCompile(t *testing.T) {
+	webhooks := []*WebHook{{Name: "chat"}}
+	assert.Error(t, (&Escalation{When: "true", Steps: []*EscalationStep{{Webhook: "chat"}}}).compile(webhooks))
+	assert.Error(t, (&Escalation{Name: "a", Steps: []*EscalationStep{{Webhook: "chat"}}}).compile(webhooks))
+	assert.Error(t, (&Escalation{Name: "a", When: "true"}).compile(webhooks))
+	assert.Error(t, (&Escalation{Name: "a", When: "true", Steps: []*EscalationStep{{Webhook: "pager"}}}).compile(webhooks))
+	assert.Error(t, (&Escalation{Name: "a", When: "true", Resolve: "(", Steps: []*EscalationStep{{Webhook: "chat"}}}).compile(webhooks))
+	assert.Error(t, (&Escalation{Name: "a", When: "true", Steps: []*EscalationStep{
+		{Webhook: "chat", Delay: Duration(time.Minute)}, {Webhook: "chat"},
+	}}).compile(webhooks))
+}
+
+func TestEscalation(t *testing.T) {
+	requests := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- string(body)
+	}))
+	defer server.Close()
+
+	expect := func(body string) {
+		select {
+		case received := <-requests:
+			assert.Equal(t, body, received)
+		case <-time.After(time.Second):
+			t.Fatalf("%q was not sent", body)
+		}
+	}
+	expectNothing := func(d time.Duration) {
+		select {
+		case received := <-requests:
+			t.Fatalf("unexpected request %q", received)
+		case <-time.After(d):
+		}
+	}
+
+	alert := func(title string, priority int) *MessageExternal {
+		return &MessageExternal{Title: title, Priority: priority, Extras: map[string]interface{}{"alert": "disk"}}
+	}
+
+	t.Run("Escalates unresolved alerts", func(t *testing.T) {
+		config := newEscalationConfig(t, server.URL, server.URL, &EscalationStep{Delay: Duration(100 * time.Millisecond)})
+		plugin := &MultiNotifierPlugin{config: config}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		plugin.startEscalations(ctx, config)
+
+		assert.Empty(t, plugin.sendMessage(ctx, alert("disk full", 8), config.WebHooks))
+		expect("chat: disk full")
+		expect("pager: disk full")
+	})
+
+	t.Run("Stops when resolved", func(t *testing.T) {
+		config := newEscalationConfig(t, server.URL, server.URL, &EscalationStep{Delay: Duration(100 * time.Millisecond)})
+		plugin := &MultiNotifierPlugin{config: config}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		plugin.startEscalations(ctx, config)
+
+		assert.Empty(t, plugin.sendMessage(ctx, alert("disk full", 8), config.WebHooks))
+		expect("chat: disk full")
+		assert.Empty(t, plugin.sendMessage(ctx, alert("[RESOLVED] disk full", 1), config.WebHooks))
+		expectNothing(200 * time.Millisecond)
+	})
+
+	t.Run("Escalates recurring alerts early", func(t *testing.T) {
+		config := newEscalationConfig(t, server.URL, server.URL, &EscalationStep{Delay: Duration(time.Hour), Repeats: 3})
+		plugin := &MultiNotifierPlugin{config: config}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		plugin.startEscalations(ctx, config)
+
+		for i := 0; i < 3; i++ {
+			assert.Empty(t, plugin.sendMessage(ctx, alert("disk full", 9), config.WebHooks))
+		}
+		expect("chat: disk full")
+		expect("pager: disk full")
+	})
+
+	t.Run("Restores persisted alerts", func(t *testing.T) {
+		storage := &memoryStorage{}
+		config := newEscalationConfig(t, server.URL, server.URL, &EscalationStep{Delay: Duration(200 * time.Millisecond)})
+		plugin := &MultiNotifierPlugin{config: config}
+		plugin.SetStorageHandler(storage)
+		ctx, cancel := context.WithCancel(context.Background())
+		plugin.startEscalations(ctx, config)
+
+		assert.Empty(t, plugin.sendMessage(ctx, alert("disk full", 8), config.WebHooks))
+		expect("chat: disk full")
+		// Alerts are persisted periodically and when the escalations stop.
+		stored, _ := plugin.loadStorage()
+		assert.Empty(t, stored.Escalations)
+		cancel()
+		assert.NoError(t, plugin.Disable())
+
+		restarted := &MultiNotifierPlugin{config: config}
+		restarted.SetStorageHandler(storage)
+		ctx, cancel = context.WithCancel(context.Background())
+		defer cancel()
+		restarted.startEscalations(ctx, config)
+		expect("pager: disk full")
+	})
+}
@@ -0,0 +1,755 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Expression is a compiled `when` expression. The language only supports literals, message
+// fields, member access and operators, there are no function calls or loops, so evaluation is
+// side-effect free and bounded by the size of the expression.
+//
+// Supported operators by precedence: `||`/`or`, `&&`/`and`, `!`/`not`, the comparisons
+// `== != < <= > >= in matches contains startsWith endsWith`, member access `.` and `[]`.
+type Expression struct {
+	source string
+	root   exprNode
+}
+
+// exprVariables are the names available in expressions, mirroring the JSON names of MessageExternal
+// plus the application name.
+var exprVariables = map[string]bool{
+	"id":       true,
+	"appid":    true,
+	"title":    true,
+	"message":  true,
+	"priority": true,
+	"extras":   true,
+	"date":     true,
+	"app":      true,
+}
+
+// ExprError describes an expression error with its position, counted in characters from 1.
+type ExprError struct {
+	Pos int
+	Msg string
+}
+
+func (e *ExprError) Error() string {
+	return fmt.Sprintf("column %d: %s", e.Pos, e.Msg)
+}
+
+// CompileExpression parses and checks an expression.
+func CompileExpression(source string) (*Expression, error) {
+	tokens, err := lexExpr(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &exprParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, &ExprError{Pos: tok.pos, Msg: fmt.Sprintf("unexpected %s", tok)}
+	}
+
+	return &Expression{source: source, root: root}, nil
+}
+
+// String returns the source of the expression.
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Match evaluates the expression against the message, the result must be a boolean.
+func (e *Expression) Match(msg *MessageExternal) (bool, error) {
+	env := map[string]interface{}{
+		"id":       float64(msg.ID),
+		"appid":    float64(msg.ApplicationID),
+		"title":    msg.Title,
+		"message":  msg.Message,
+		"priority": float64(msg.Priority),
+		"extras":   normalizeExprValue(msg.Extras),
+		"date":     msg.Date.Format(time.RFC3339),
+		"app":      nil,
+	}
+	if msg.app != nil {
+		env["app"] = msg.app.Name
+	}
+
+	v, err := e.root.eval(env)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expression result is %s, not a boolean", exprTypeName(v))
+	}
+	return b, nil
+}
+
+// normalizeExprValue converts numbers to float64 so values from extras compare with literals.
+func normalizeExprValue(v interface{}) interface{} {
+	switch vv := v.(type) {
+	case int:
+		return float64(vv)
+	case int64:
+		return float64(vv)
+	case uint:
+		return float64(vv)
+	case uint64:
+		return float64(vv)
+	case float32:
+		return float64(vv)
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(vv))
+		for k, item := range vv {
+			m[k] = normalizeExprValue(item)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(vv))
+		for i, item := range vv {
+			l[i] = normalizeExprValue(item)
+		}
+		return l
+	default:
+		return v
+	}
+}
+
+func exprTypeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "bool"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "map"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+// Lexer
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokOperator
+)
+
+type exprToken struct {
+	kind tokenKind
+	text string
+	num  float64
+	pos  int
+}
+
+func (t exprToken) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokString:
+		return strconv.Quote(t.text)
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+var exprOperators = []string{"||", "&&", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")", "[", "]", ",", ".", "-"}
+
+func lexExpr(source string) ([]exprToken, error) {
+	var tokens []exprToken
+	col := 1
+
+	for i := 0; i < len(source); {
+		r, size := utf8.DecodeRuneInString(source[i:])
+		pos := col
+
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+			col++
+			continue
+
+		case r == '"' || r == '\'':
+			end := i + size
+			escaped := false
+			for end < len(source) {
+				c := source[end]
+				if escaped {
+					escaped = false
+				} else if c == '\\' {
+					escaped = true
+				} else if rune(c) == r {
+					break
+				}
+				end++
+			}
+			if end >= len(source) {
+				return nil, &ExprError{Pos: pos, Msg: "unterminated string"}
+			}
+			text, err := unquoteExpr(source[i+size:end], byte(r))
+			if err != nil {
+				return nil, &ExprError{Pos: pos, Msg: "invalid string literal"}
+			}
+			tokens = append(tokens, exprToken{kind: tokString, text: text, pos: pos})
+			col += utf8.RuneCountInString(source[i : end+1])
+			i = end + 1
+			continue
+
+		case r >= '0' && r <= '9':
+			end := i
+			for end < len(source) && (source[end] >= '0' && source[end] <= '9' || source[end] == '.') {
+				end++
+			}
+			num, err := strconv.ParseFloat(source[i:end], 64)
+			if err != nil {
+				return nil, &ExprError{Pos: pos, Msg: fmt.Sprintf("invalid number %q", source[i:end])}
+			}
+			tokens = append(tokens, exprToken{kind: tokNumber, text: source[i:end], num: num, pos: pos})
+			col += end - i
+			i = end
+			continue
+
+		case r == '_' || unicode.IsLetter(r):
+			end := i
+			for end < len(source) {
+				c, s := utf8.DecodeRuneInString(source[end:])
+				if c != '_' && !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+					break
+				}
+				end += s
+			}
+			tokens = append(tokens, exprToken{kind: tokIdent, text: source[i:end], pos: pos})
+			col += utf8.RuneCountInString(source[i:end])
+			i = end
+			continue
+		}
+
+		matched := false
+		for _, op := range exprOperators {
+			if strings.HasPrefix(source[i:], op) {
+				tokens = append(tokens, exprToken{kind: tokOperator, text: op, pos: pos})
+				i += len(op)
+				col += len(op)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return nil, &ExprError{Pos: pos, Msg: fmt.Sprintf("unexpected character %q", r)}
+		}
+	}
+
+	return append(tokens, exprToken{kind: tokEOF, pos: col}), nil
+}
+
+// unquoteExpr resolves the escape sequences of a string literal without its quotes.
+func unquoteExpr(s string, quote byte) (string, error) {
+	var sb strings.Builder
+	for len(s) > 0 {
+		r, multibyte, tail, err := strconv.UnquoteChar(s, quote)
+		if err != nil {
+			return "", err
+		}
+		if multibyte || r >= utf8.RuneSelf {
+			sb.WriteRune(r)
+		} else {
+			sb.WriteByte(byte(r))
+		}
+		s = tail
+	}
+	return sb.String(), nil
+}
+
+// Parser
+
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() exprToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// accept consumes the next token if it is one of the operators or keywords.
+func (p *exprParser) accept(texts ...string) (exprToken, bool) {
+	tok := p.peek()
+	if tok.kind != tokOperator && tok.kind != tokIdent {
+		return tok, false
+	}
+	for _, text := range texts {
+		if tok.text == text {
+			p.pos++
+			return tok, true
+		}
+	}
+	return tok, false
+}
+
+func (p *exprParser) expect(text string) error {
+	if tok, ok := p.accept(text); !ok {
+		return &ExprError{Pos: tok.pos, Msg: fmt.Sprintf("expected %q, found %s", text, tok)}
+	}
+	return nil
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok, ok := p.accept("||", "or")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{op: "||", pos: tok.pos, left: left, right: right}
+	}
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok, ok := p.accept("&&", "and")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{op: "&&", pos: tok.pos, left: left, right: right}
+	}
+}
+
+func (p *exprParser) parseNot() (exprNode, error) {
+	if tok, ok := p.accept("!", "not"); ok {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{pos: tok.pos, operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *exprParser) parseComparison() (exprNode, error) {
+	left, err := p.parsePostfix()
+	if err != nil {
+		return nil, err
+	}
+
+	tok, ok := p.accept("==", "!=", "<", "<=", ">", ">=", "in", "matches", "contains", "startsWith", "endsWith")
+	if !ok {
+		return left, nil
+	}
+	right, err := p.parsePostfix()
+	if err != nil {
+		return nil, err
+	}
+
+	node := &compareNode{op: tok.text, pos: tok.pos, left: left, right: right}
+	if tok.text == "matches" {
+		if lit, ok := right.(*literalNode); ok {
+			s, ok := lit.value.(string)
+			if !ok {
+				return nil, &ExprError{Pos: lit.pos, Msg: "matches requires a string pattern"}
+			}
+			re, err := regexp.Compile(s)
+			if err != nil {
+				return nil, &ExprError{Pos: lit.pos, Msg: fmt.Sprintf("invalid regex: %v", err)}
+			}
+			node.re = re
+		}
+	}
+	return node, nil
+}
+
+func (p *exprParser) parsePostfix() (exprNode, error) {
+	node, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if tok, ok := p.accept("."); ok {
+			name := p.next()
+			if name.kind != tokIdent {
+				return nil, &ExprError{Pos: name.pos, Msg: fmt.Sprintf("expected field name, found %s", name)}
+			}
+			node = &indexNode{pos: tok.pos, target: node, index: &literalNode{pos: name.pos, value: name.text}}
+			continue
+		}
+		if tok, ok := p.accept("["); ok {
+			index, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			node = &indexNode{pos: tok.pos, target: node, index: index}
+			continue
+		}
+		return node, nil
+	}
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokNumber:
+		return &literalNode{pos: tok.pos, value: tok.num}, nil
+	case tokString:
+		return &literalNode{pos: tok.pos, value: tok.text}, nil
+	case tokIdent:
+		switch tok.text {
+		case "true":
+			return &literalNode{pos: tok.pos, value: true}, nil
+		case "false":
+			return &literalNode{pos: tok.pos, value: false}, nil
+		case "nil", "null":
+			return &literalNode{pos: tok.pos, value: nil}, nil
+		}
+		if !exprVariables[tok.text] {
+			return nil, &ExprError{Pos: tok.pos, Msg: fmt.Sprintf("unknown variable %q", tok.text)}
+		}
+		return &variableNode{pos: tok.pos, name: tok.text}, nil
+	case tokOperator:
+		switch tok.text {
+		case "(":
+			node, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return node, nil
+		case "[":
+			list := &listNode{pos: tok.pos}
+			if _, ok := p.accept("]"); ok {
+				return list, nil
+			}
+			for {
+				item, err := p.parseOr()
+				if err != nil {
+					return nil, err
+				}
+				list.items = append(list.items, item)
+				if _, ok := p.accept(","); ok {
+					continue
+				}
+				if err := p.expect("]"); err != nil {
+					return nil, err
+				}
+				return list, nil
+			}
+		case "-":
+			num := p.next()
+			if num.kind != tokNumber {
+				return nil, &ExprError{Pos: num.pos, Msg: fmt.Sprintf("expected number, found %s", num)}
+			}
+			return &literalNode{pos: tok.pos, value: -num.num}, nil
+		}
+	}
+	return nil, &ExprError{Pos: tok.pos, Msg: fmt.Sprintf("unexpected %s", tok)}
+}
+
+// AST
+
+type exprNode interface {
+	eval(env map[string]interface{}) (interface{}, error)
+}
+
+type literalNode struct {
+	pos   int
+	value interface{}
+}
+
+func (n *literalNode) eval(map[string]interface{}) (interface{}, error) {
+	return n.value, nil
+}
+
+type variableNode struct {
+	pos  int
+	name string
+}
+
+func (n *variableNode) eval(env map[string]interface{}) (interface{}, error) {
+	return env[n.name], nil
+}
+
+type listNode struct {
+	pos   int
+	items []exprNode
+}
+
+func (n *listNode) eval(env map[string]interface{}) (interface{}, error) {
+	list := make([]interface{}, 0, len(n.items))
+	for _, item := range n.items {
+		v, err := item.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, nil
+}
+
+// indexNode accesses a map key or array element, missing entries evaluate to nil.
+type indexNode struct {
+	pos    int
+	target exprNode
+	index  exprNode
+}
+
+func (n *indexNode) eval(env map[string]interface{}) (interface{}, error) {
+	target, err := n.target.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	index, err := n.index.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := target.(type) {
+	case map[string]interface{}:
+		if key, ok := index.(string); ok {
+			return t[key], nil
+		}
+	case []interface{}:
+		if i, ok := index.(float64); ok && i >= 0 && int(i) < len(t) && float64(int(i)) == i {
+			return t[int(i)], nil
+		}
+	}
+	return nil, nil
+}
+
+type notNode struct {
+	pos     int
+	operand exprNode
+}
+
+func (n *notNode) eval(env map[string]interface{}) (interface{}, error) {
+	v, err := n.operand.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return nil, &ExprError{Pos: n.pos, Msg: fmt.Sprintf("operand of ! is %s, not a boolean", exprTypeName(v))}
+	}
+	return !b, nil
+}
+
+type logicalNode struct {
+	op          string
+	pos         int
+	left, right exprNode
+}
+
+func (n *logicalNode) eval(env map[string]interface{}) (interface{}, error) {
+	left, err := n.evalBool(n.left, env)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "&&" && !left {
+		return false, nil
+	}
+	if n.op == "||" && left {
+		return true, nil
+	}
+	return n.evalBool(n.right, env)
+}
+
+func (n *logicalNode) evalBool(node exprNode, env map[string]interface{}) (bool, error) {
+	v, err := node.eval(env)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, &ExprError{Pos: n.pos, Msg: fmt.Sprintf("operand of %s is %s, not a boolean", n.op, exprTypeName(v))}
+	}
+	return b, nil
+}
+
+type compareNode struct {
+	op          string
+	pos         int
+	left, right exprNode
+	re          *regexp.Regexp
+}
+
+func (n *compareNode) eval(env map[string]interface{}) (interface{}, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return exprEqual(left, right), nil
+	case "!=":
+		return !exprEqual(left, right), nil
+	case "<", "<=", ">", ">=":
+		return n.order(left, right)
+	case "in":
+		return n.in(left, right)
+	case "matches":
+		s, ok := left.(string)
+		if !ok {
+			return false, nil
+		}
+		re := n.re
+		if re == nil {
+			pattern, ok := right.(string)
+			if !ok {
+				return nil, &ExprError{Pos: n.pos, Msg: "matches requires a string pattern"}
+			}
+			if re, err = regexp.Compile(pattern); err != nil {
+				return nil, &ExprError{Pos: n.pos, Msg: fmt.Sprintf("invalid regex: %v", err)}
+			}
+		}
+		return re.MatchString(s), nil
+	default:
+		s, ok1 := left.(string)
+		sub, ok2 := right.(string)
+		if !ok1 || !ok2 {
+			return false, nil
+		}
+		switch n.op {
+		case "contains":
+			return strings.Contains(s, sub), nil
+		case "startsWith":
+			return strings.HasPrefix(s, sub), nil
+		default:
+			return strings.HasSuffix(s, sub), nil
+		}
+	}
+}
+
+func (n *compareNode) order(left, right interface{}) (interface{}, error) {
+	var cmp int
+	switch l := left.(type) {
+	case float64:
+		r, ok := right.(float64)
+		if !ok {
+			return nil, n.typeError(left, right)
+		}
+		switch {
+		case l < r:
+			cmp = -1
+		case l > r:
+			cmp = 1
+		}
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return nil, n.typeError(left, right)
+		}
+		cmp = strings.Compare(l, r)
+	default:
+		return nil, n.typeError(left, right)
+	}
+
+	switch n.op {
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	default:
+		return cmp >= 0, nil
+	}
+}
+
+func (n *compareNode) in(left, right interface{}) (interface{}, error) {
+	switch r := right.(type) {
+	case []interface{}:
+		for _, item := range r {
+			if exprEqual(left, item) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case map[string]interface{}:
+		key, ok := left.(string)
+		if !ok {
+			return false, nil
+		}
+		_, exists := r[key]
+		return exists, nil
+	case string:
+		s, ok := left.(string)
+		return ok && strings.Contains(r, s), nil
+	case nil:
+		return false, nil
+	default:
+		return nil, n.typeError(left, right)
+	}
+}
+
+func (n *compareNode) typeError(left, right interface{}) error {
+	return &ExprError{Pos: n.pos, Msg: fmt.Sprintf("can't apply %s to %s and %s", n.op, exprTypeName(left), exprTypeName(right))}
+}
+
+func exprEqual(a, b interface{}) bool {
+	switch av := a.(type) {
+	case nil:
+		return b == nil
+	case bool:
+		bv, ok := b.(bool)
+		return ok && av == bv
+	case float64:
+		bv, ok := b.(float64)
+		return ok && av == bv
+	case string:
+		bv, ok := b.(string)
+		return ok && av == bv
+	default:
+		return false
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpressionMatch(t *testing.T) {
+	msg := &MessageExternal{
+		ID:            42,
+		ApplicationID: 7,
+		Title:         "PROD: disk full",
+		Message:       "/var is at 99%",
+		Priority:      8,
+		Extras: map[string]interface{}{
+			"client::display": map[string]interface{}{"contentType": "text/markdown"},
+			"host":            "db-1",
+			"tags":            []interface{}{"storage", "db"},
+			"retries":         3,
+		},
+	}
+
+	testCases := []struct {
+		expr  string
+		match bool
+	}{
+		{`priority >= 8 && appid in [3,7] && title matches "(?i)prod"`, true},
+		{`priority > 8 || appid == 1`, false},
+		{`!(priority < 5) and not (title contains "staging")`, true},
+		{`extras["client::display"].contentType == "text/markdown"`, true},
+		{`extras.host startsWith 'db-' && extras.retries == 3`, true},
+		{`"db" in extras.tags`, true},
+		{`extras.tags[0] == "storage"`, true},
+		{`extras.missing.key == nil`, true},
+		{`"host" in extras && message endsWith "%"`, true},
+		{`priority >= -1 && id != 42`, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.expr, func(t *testing.T) {
+			expr, err := CompileExpression(tc.expr)
+			if !assert.NoError(t, err) {
+				return
+			}
+			matched, err := expr.Match(msg)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.match, matched)
+		})
+	}
+}
+
+func TestCompileExpressionErrors(t *testing.T) {
+	testCases := []struct {
+		expr string
+		err  string
+	}{
+		{`priority >= `, "column 13: unexpected end of expression"},
+		{`prio > 3`, `column 1: unknown variable "prio"`},
+		{`title matches "("`, "column 15: invalid regex"},
+		{`appid in [1, 2`, `column 15: expected "]", found end of expression`},
+		{`title == "open`, "column 10: unterminated string"},
+		{`priority # 3`, `column 10: unexpected character '#'`},
+		{`priority > 3 title`, `column 14: unexpected "title"`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.expr, func(t *testing.T) {
+			_, err := CompileExpression(tc.expr)
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tc.err)
+			}
+		})
+	}
+}
+
+func TestExpressionEvaluationErrors(t *testing.T) {
+	msg := &MessageExternal{Title: "title", Priority: 5}
+
+	for _, source := range []string{`priority`, `title > 3`, `priority && true`} {
+		expr, err := CompileExpression(source)
+		assert.NoError(t, err)
+		_, err = expr.Match(msg)
+		assert.Error(t, err, source)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Filter describes which messages are forwarded to a webhook. All conditions set on a filter
+// must match, All, Any and Not combine nested filters.
+type Filter struct {
+	Priority    *PriorityRange `yaml:"priority"`
+	Title       *TextMatch     `yaml:"title"`
+	Message     *TextMatch     `yaml:"message"`
+	Extras      []*ExtraMatch  `yaml:"extras"`
+	ExcludeApps []uint         `yaml:"exclude_apps"`
+	All         []*Filter      `yaml:"all"`
+	Any         []*Filter      `yaml:"any"`
+	Not         *Filter        `yaml:"not"`
+}
+
+// PriorityRange matches priorities between Min and Max, both inclusive and optional.
+type PriorityRange struct {
+	Min *int `yaml:"min"`
+	Max *int `yaml:"max"`
+}
+
+// TextMatch matches a text by substring and/or regular expression.
+type TextMatch struct {
+	Contains string `yaml:"contains"`
+	Regex    string `yaml:"regex"`
+
+	re *regexp.Regexp
+}
+
+// ExtraMatch matches the presence of an extras key, or its value if Value or Regex is set.
+// Nested keys are separated by dots, e.g. client::notification.click.url.
+type ExtraMatch struct {
+	Key   string `yaml:"key"`
+	Value string `yaml:"value"`
+	Regex string `yaml:"regex"`
+
+	re *regexp.Regexp
+}
+
+// compile validates the filter and compiles its regular expressions.
+func (f *Filter) compile() error {
+	if f == nil {
+		return nil
+	}
+
+	if f.Priority != nil && f.Priority.Min != nil && f.Priority.Max != nil && *f.Priority.Min > *f.Priority.Max {
+		return fmt.Errorf("priority min %d is greater than max %d", *f.Priority.Min, *f.Priority.Max)
+	}
+	if err := f.Title.compile(); err != nil {
+		return fmt.Errorf("title: %w", err)
+	}
+	if err := f.Message.compile(); err != nil {
+		return fmt.Errorf("message: %w", err)
+	}
+	for _, extra := range f.Extras {
+		if extra.Key == "" {
+			return fmt.Errorf("extras: key is required")
+		}
+		if extra.Regex != "" {
+			re, err := regexp.Compile(extra.Regex)
+			if err != nil {
+				return fmt.Errorf("extras %s: invalid regex: %w", extra.Key, err)
+			}
+			extra.re = re
+		}
+	}
+	for _, sub := range f.All {
+		if err := sub.compile(); err != nil {
+			return fmt.Errorf("all: %w", err)
+		}
+	}
+	for _, sub := range f.Any {
+		if err := sub.compile(); err != nil {
+			return fmt.Errorf("any: %w", err)
+		}
+	}
+	if err := f.Not.compile(); err != nil {
+		return fmt.Errorf("not: %w", err)
+	}
+
+	return nil
+}
+
+func (m *TextMatch) compile() error {
+	if m == nil || m.Regex == "" {
+		return nil
+	}
+	re, err := regexp.Compile(m.Regex)
+	if err != nil {
+		return fmt.Errorf("invalid regex: %w", err)
+	}
+	m.re = re
+	return nil
+}
+
+// Match reports whether the message passes the filter. A nil filter matches every message.
+func (f *Filter) Match(msg *MessageExternal) bool {
+	if f == nil {
+		return true
+	}
+
+	for _, appID := range f.ExcludeApps {
+		if appID == msg.ApplicationID {
+			return false
+		}
+	}
+	if f.Priority != nil {
+		if f.Priority.Min != nil && msg.Priority < *f.Priority.Min {
+			return false
+		}
+		if f.Priority.Max != nil && msg.Priority > *f.Priority.Max {
+			return false
+		}
+	}
+	if !f.Title.Match(msg.Title) || !f.Message.Match(msg.Message) {
+		return false
+	}
+	for _, extra := range f.Extras {
+		if !extra.Match(msg.Extras) {
+			return false
+		}
+	}
+	for _, sub := range f.All {
+		if !sub.Match(msg) {
+			return false
+		}
+	}
+	if len(f.Any) > 0 {
+		anyMatched := false
+		for _, sub := range f.Any {
+			if sub.Match(msg) {
+				anyMatched = true
+				break
+			}
+		}
+		if !anyMatched {
+			return false
+		}
+	}
+	if f.Not != nil && f.Not.Match(msg) {
+		return false
+	}
+
+	return true
+}
+
+// Match reports whether the text matches. A nil TextMatch matches every text.
+func (m *TextMatch) Match(s string) bool {
+	if m == nil {
+		return true
+	}
+	if m.Contains != "" && !strings.Contains(s, m.Contains) {
+		return false
+	}
+	if m.re != nil && !m.re.MatchString(s) {
+		return false
+	}
+	return true
+}
+
+// Match reports whether the extras contain the key with a matching value.
+func (m *ExtraMatch) Match(extras map[string]interface{}) bool {
+	value, ok := lookupExtra(extras, m.Key)
+	if !ok {
+		return false
+	}
+	s := fmt.Sprint(value)
+	if m.Value != "" && s != m.Value {
+		return false
+	}
+	if m.re != nil && !m.re.MatchString(s) {
+		return false
+	}
+	return true
+}
+
+// lookupExtra resolves a dot separated key in the message extras.
+func lookupExtra(extras map[string]interface{}, key string) (interface{}, bool) {
+	var current interface{} = extras
+	for _, part := range strings.Split(key, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current, ok = m[part]
+		if !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestFilterMatch(t *testing.T) {
+	msg := &MessageExternal{
+		ApplicationID: 3,
+		Title:         "PROD: disk full",
+		Message:       "/var is at 99%",
+		Priority:      8,
+		Extras: map[string]interface{}{
+			"client::display": map[string]interface{}{"contentType": "text/markdown"},
+			"host":            "db-1",
+		},
+	}
+
+	testCases := []struct {
+		name   string
+		filter *Filter
+		match  bool
+	}{
+		{
+			name:  "Nil filter",
+			match: true,
+		},
+		{
+			name:   "Priority in range",
+			filter: &Filter{Priority: &PriorityRange{Min: intPtr(5), Max: intPtr(8)}},
+			match:  true,
+		},
+		{
+			name:   "Priority below minimum",
+			filter: &Filter{Priority: &PriorityRange{Min: intPtr(9)}},
+			match:  false,
+		},
+		{
+			name:   "Title regex",
+			filter: &Filter{Title: &TextMatch{Regex: "(?i)^prod"}},
+			match:  true,
+		},
+		{
+			name:   "Message substring mismatch",
+			filter: &Filter{Message: &TextMatch{Contains: "/home"}},
+			match:  false,
+		},
+		{
+			name:   "Nested extras value",
+			filter: &Filter{Extras: []*ExtraMatch{{Key: "client::display.contentType", Value: "text/markdown"}}},
+			match:  true,
+		},
+		{
+			name:   "Missing extras key",
+			filter: &Filter{Extras: []*ExtraMatch{{Key: "client::notification"}}},
+			match:  false,
+		},
+		{
+			name:   "Excluded app",
+			filter: &Filter{ExcludeApps: []uint{1, 3}},
+			match:  false,
+		},
+		{
+			name: "Any of",
+			filter: &Filter{Any: []*Filter{
+				{Priority: &PriorityRange{Min: intPtr(10)}},
+				{Extras: []*ExtraMatch{{Key: "host", Regex: "^db-"}}},
+			}},
+			match: true,
+		},
+		{
+			name:   "Not",
+			filter: &Filter{Not: &Filter{Title: &TextMatch{Contains: "PROD"}}},
+			match:  false,
+		},
+		{
+			name: "All of",
+			filter: &Filter{All: []*Filter{
+				{Priority: &PriorityRange{Min: intPtr(5)}},
+				{Not: &Filter{Message: &TextMatch{Contains: "99%"}}},
+			}},
+			match: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.NoError(t, tc.filter.compile())
+			assert.Equal(t, tc.match, tc.filter.Match(msg))
+		})
+	}
+}
+
+func TestFilterCompile(t *testing.T) {
+	assert.Error(t, (&Filter{Title: &TextMatch{Regex: "("}}).compile())
+	assert.Error(t, (&Filter{Priority: &PriorityRange{Min: intPtr(8), Max: intPtr(2)}}).compile())
+	assert.Error(t, (&Filter{Any: []*Filter{{Extras: []*ExtraMatch{{}}}}}).compile())
+}
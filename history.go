@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// defaultHistorySize is the number of deliveries kept in the history unless configured otherwise.
+	defaultHistorySize = 200
+	// maxHistoryResponse limits the size of the response bodies kept in the history.
+	maxHistoryResponse = 512
+	// defaultHistoryLimit is the number of deliveries returned by the history route by default.
+	defaultHistoryLimit = 50
+)
+
+// historyQuery selects deliveries from the history, zero values match all deliveries.
+type historyQuery struct {
+	webhook string
+	app     uint
+	status  string
+	since   time.Time
+	until   time.Time
+	limit   int
+}
+
+// parseHistoryQuery parses the query parameters webhook, app, status, since, until and limit.
+func parseHistoryQuery(values url.Values) (*historyQuery, error) {
+	q := &historyQuery{
+		webhook: values.Get("webhook"),
+		status:  values.Get("status"),
+		limit:   defaultHistoryLimit,
+	}
+	switch q.status {
+	case "", DeliverySucceeded, DeliveryFailed:
+	default:
+		return nil, fmt.Errorf("status must be %s or %s", DeliverySucceeded, DeliveryFailed)
+	}
+	if app := values.Get("app"); app != "" {
+		id, err := strconv.ParseUint(app, 10, 0)
+		if err != nil {
+			return nil, fmt.Errorf("invalid app %q", app)
+		}
+		q.app = uint(id)
+	}
+	for _, param := range []struct {
+		name string
+		t    *time.Time
+	}{{"since", &q.since}, {"until", &q.until}} {
+		value := values.Get(param.name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q, expected RFC 3339", param.name, value)
+		}
+		*param.t = t
+	}
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid limit %q", limit)
+		}
+		q.limit = n
+	}
+	return q, nil
+}
+
+func (q *historyQuery) match(record *DeliveryRecord) bool {
+	if q.webhook != "" && q.webhook != record.Webhook {
+		return false
+	}
+	if q.app != 0 && !record.hasApp(q.app) {
+		return false
+	}
+	if q.status != "" && q.status != record.Status {
+		return false
+	}
+	if !q.since.IsZero() && record.Time.Before(q.since) {
+		return false
+	}
+	if !q.until.IsZero() && !record.Time.Before(q.until) {
+		return false
+	}
+	return true
+}
+
+func (r *DeliveryRecord) hasApp(appID uint) bool {
+	for _, id := range r.AppIDs {
+		if id == appID {
+			return true
+		}
+	}
+	return false
+}
+
+// deliveries returns the deliveries matching the query, the latest first.
+func (s *deliveryStats) deliveries(q *historyQuery) []*DeliveryRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records := make([]*DeliveryRecord, 0)
+	for i := len(s.history) - 1; i >= 0 && (q.limit == 0 || len(records) < q.limit); i-- {
+		if q.match(s.history[i]) {
+			records = append(records, s.history[i])
+		}
+	}
+	return records
+}
+
+// historySnapshot returns a copy of the history, the latest last.
+func (s *deliveryStats) historySnapshot() []*DeliveryRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*DeliveryRecord(nil), s.history...)
+}
+
+// newDeliveryRecord starts the record of a delivery of the messages.
+func newDeliveryRecord(msgs []*MessageExternal) *DeliveryRecord {
+	record := &DeliveryRecord{Time: time.Now()}
+	apps := make(map[uint]bool)
+	for _, msg := range msgs {
+		record.MessageIDs = append(record.MessageIDs, msg.ID)
+		if !apps[msg.ApplicationID] {
+			apps[msg.ApplicationID] = true
+			record.AppIDs = append(record.AppIDs, msg.ApplicationID)
+		}
+	}
+	return record
+}
+
+// responseExcerpt reads the beginning of a response body for the history.
+func responseExcerpt(body io.Reader) string {
+	data, _ := io.ReadAll(io.LimitReader(body, maxHistoryResponse+1))
+	excerpt := string(data)
+	if len(data) > maxHistoryResponse {
+		excerpt = strings.ToValidUTF8(string(data[:maxHistoryResponse]), "") + "..."
+	}
+	return maskSecrets(excerpt)
+}
+
+func (p *MultiNotifierPlugin) handleHistory(c *gin.Context) {
+	config := p.currentConfig()
+	if config == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "plugin is not configured"})
+		return
+	}
+	if !authorizeToken(c, config.APIToken) {
+		return
+	}
+
+	q, err := parseHistoryQuery(c.Request.URL.Query())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	records := p.stats.deliveries(q)
+	deliveries := make([]DeliveryRecord, 0, len(records))
+	for _, record := range records {
+		deliveries = append(deliveries, *record)
+	}
+	c.JSON(http.StatusOK, gin.H{"deliveries": deliveries})
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseHistoryQuery(t *testing.T) {
+	q, err := parseHistoryQuery(url.Values{"webhook": {"chat"}, "app": {"3"}, "status": {"failed"}, "since": {"2024-05-01T10:00:00Z"}, "limit": {"5"}})
+	assert.NoError(t, err)
+	assert.Equal(t, &historyQuery{webhook: "chat", app: 3, status: DeliveryFailed, since: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC), limit: 5}, q)
+
+	q, err = parseHistoryQuery(url.Values{})
+	assert.NoError(t, err)
+	assert.Equal(t, defaultHistoryLimit, q.limit)
+
+	for _, values := range []url.Values{
+		{"status": {"pending"}},
+		{"app": {"chat"}},
+		{"until": {"yesterday"}},
+		{"limit": {"0"}},
+	} {
+		_, err := parseHistoryQuery(values)
+		assert.Error(t, err, values)
+	}
+}
+
+func TestDeliveryHistory(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "down") {
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte(strings.Repeat("x", maxHistoryResponse+10)))
+			return
+		}
+		w.Write([]byte(`{"ok": true}`))
+	}))
+	defer server.Close()
+
+	storage := &memoryStorage{}
+	p := &MultiNotifierPlugin{}
+	p.SetStorageHandler(storage)
+	assert.NoError(t, p.ValidateAndSetConfig(&Config{
+		HostServer:  "ws://localhost",
+		APIToken:    "secret",
+		HistorySize: 3,
+		WebHooks: []*WebHook{
+			{Name: "chat", Url: server.URL},
+			{Name: "pager", Url: server.URL + "/down", Apps: AppList{IDs: []uint{2}}, Retry: &Retry{Attempts: 2, Backoff: Duration(time.Millisecond)}},
+		},
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	p.startStats(ctx)
+
+	p.sendMessage(ctx, &MessageExternal{ID: 10, ApplicationID: 1, Title: "a"}, p.config.WebHooks)
+	p.sendMessage(ctx, &MessageExternal{ID: 11, ApplicationID: 2, Title: "b"}, p.config.WebHooks)
+
+	router := newTestRouter(p)
+	history := func(query string) (int, []DeliveryRecord) {
+		req := httptest.NewRequest(http.MethodGet, "/plugin/1/custom/xyz/history"+query, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var res struct {
+			Deliveries []DeliveryRecord `json:"deliveries"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &res)
+		return w.Code, res.Deliveries
+	}
+
+	code, deliveries := history("")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Len(t, deliveries, 3)
+
+	code, deliveries = history("?status=failed&app=2")
+	assert.Equal(t, http.StatusOK, code)
+	if assert.Len(t, deliveries, 1) {
+		d := deliveries[0]
+		assert.Equal(t, "pager", d.Webhook)
+		assert.Equal(t, []uint{11}, d.MessageIDs)
+		assert.Equal(t, 2, d.Attempts)
+		assert.Equal(t, http.StatusBadGateway, d.StatusCode)
+		assert.Equal(t, strings.Repeat("x", maxHistoryResponse)+"...", d.Response)
+		assert.Equal(t, "unexpected status code: 502", d.Error)
+		// The retry waits a millisecond before the second attempt.
+		assert.GreaterOrEqual(t, d.DurationMS, int64(1))
+	}
+
+	_, deliveries = history("?webhook=chat&limit=1")
+	if assert.Len(t, deliveries, 1) {
+		assert.Equal(t, []uint{11}, deliveries[0].MessageIDs)
+		assert.Equal(t, `{"ok": true}`, deliveries[0].Response)
+	}
+
+	_, deliveries = history("?until=" + url.QueryEscape(time.Now().Add(-time.Hour).Format(time.RFC3339)))
+	assert.Empty(t, deliveries)
+
+	code, _ = history("?status=unknown")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/plugin/1/custom/xyz/history", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// The token is only accepted as a bearer token.
+	req := httptest.NewRequest(http.MethodGet, "/plugin/1/custom/xyz/history", nil)
+	req.Header.Set("Authorization", "secret")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// The history is persisted with the statistics.
+	cancel()
+	assert.Eventually(t, func() bool {
+		persisted, err := p.loadStorage()
+		return err == nil && len(persisted.History) == 3
+	}, time.Second, 10*time.Millisecond)
+
+	restored := &MultiNotifierPlugin{}
+	restored.SetStorageHandler(storage)
+	restored.stats.setHistorySize(2)
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	restored.startStats(ctx)
+	records := restored.stats.deliveries(&historyQuery{})
+	assert.Len(t, records, 2)
+	assert.Equal(t, DeliveryFailed, records[0].Status)
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gotify/plugin-api"
+)
+
+// maxInboundBody limits the size of inbound request bodies.
+const maxInboundBody = 1 << 20
+
+var inboundNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// InboundRoute turns requests to <plugin webhook path>/inbound/<name> into Gotify messages.
+//
+// Parser selects a built-in parser for well-known payloads, the mappings then override the
+// fields of the parsed message. Every mapping is either a JSON path like `$.alert.labels.severity`
+// into the request body, or a template with the placeholders `.body` (parsed JSON or form body),
+// `.raw`, `.headers` and `.query`.
+type InboundRoute struct {
+	Name     string            `yaml:"name"`
+	Parser   string            `yaml:"parser"`
+	Title    string            `yaml:"title"`
+	Message  string            `yaml:"message"`
+	Priority string            `yaml:"priority"`
+	Extras   map[string]string `yaml:"extras"`
+	// Auth restricts who may post to the route, without it the route is open to everyone.
+	Auth *InboundAuth `yaml:"auth"`
+}
+
+func (r *InboundRoute) compile() error {
+	if !inboundNamePattern.MatchString(r.Name) {
+		return fmt.Errorf("name must only contain letters, digits, - and _")
+	}
+	if r.Parser != "" {
+		if _, ok := inboundParsers[r.Parser]; !ok {
+			return fmt.Errorf("unknown parser %q", r.Parser)
+		}
+	} else if r.Message == "" {
+		return fmt.Errorf("message is required")
+	}
+	if r.Auth == nil {
+		return fmt.Errorf("auth is required, set auth.insecure to accept requests from everyone")
+	}
+	if err := r.Auth.compile(); err != nil {
+		return fmt.Errorf("invalid auth: %w", err)
+	}
+	for _, mapping := range r.mappings() {
+		if isJSONPath(mapping) {
+			continue
+		}
+		if _, err := template.New("").Parse(mapping); err != nil {
+			return fmt.Errorf("invalid template %q: %w", mapping, err)
+		}
+	}
+	return nil
+}
+
+func (r *InboundRoute) mappings() []string {
+	mappings := []string{r.Title, r.Message, r.Priority}
+	for _, mapping := range r.Extras {
+		mappings = append(mappings, mapping)
+	}
+	return mappings
+}
+
+func findInboundRoute(routes []*InboundRoute, name string) *InboundRoute {
+	for _, route := range routes {
+		if route.Name == name {
+			return route
+		}
+	}
+	return nil
+}
+
+// inboundData returns the placeholders available to inbound mappings.
+func inboundData(req *http.Request, raw []byte) map[string]interface{} {
+	headers := make(map[string]interface{}, len(req.Header))
+	for k := range req.Header {
+		headers[k] = req.Header.Get(k)
+	}
+	query := make(map[string]interface{})
+	for k := range req.URL.Query() {
+		query[k] = req.URL.Query().Get(k)
+	}
+
+	var body interface{}
+	if err := json.Unmarshal(raw, &body); err != nil {
+		body = nil
+		if values, err := url.ParseQuery(string(raw)); err == nil && strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+			form := make(map[string]interface{}, len(values))
+			for k := range values {
+				form[k] = values.Get(k)
+			}
+			body = form
+		}
+	}
+
+	return map[string]interface{}{
+		"body":    body,
+		"raw":     string(raw),
+		"headers": headers,
+		"query":   query,
+	}
+}
+
+// message maps the inbound request to a Gotify message.
+func (r *InboundRoute) message(req *http.Request, data map[string]interface{}) (*plugin.Message, error) {
+	msg := &plugin.Message{}
+	if r.Parser != "" {
+		parsed, err := inboundParsers[r.Parser](req, data["body"])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", r.Parser, err)
+		}
+		msg = parsed
+	}
+
+	var err error
+	if r.Title != "" {
+		if msg.Title, err = evalInboundString(r.Title, data); err != nil {
+			return nil, fmt.Errorf("title: %w", err)
+		}
+	}
+	if r.Message != "" {
+		if msg.Message, err = evalInboundString(r.Message, data); err != nil {
+			return nil, fmt.Errorf("message: %w", err)
+		}
+	}
+
+	if r.Priority != "" {
+		s, err := evalInboundString(r.Priority, data)
+		if err != nil {
+			return nil, fmt.Errorf("priority: %w", err)
+		}
+		if s = strings.TrimSpace(s); s != "" {
+			if msg.Priority, err = strconv.Atoi(s); err != nil {
+				return nil, fmt.Errorf("priority: %q is not a number", s)
+			}
+		}
+	}
+
+	for key, mapping := range r.Extras {
+		value, err := evalInbound(mapping, data)
+		if err != nil {
+			return nil, fmt.Errorf("extras %s: %w", key, err)
+		}
+		if value == nil {
+			continue
+		}
+		if msg.Extras == nil {
+			msg.Extras = make(map[string]interface{})
+		}
+		msg.Extras[key] = value
+	}
+
+	return msg, nil
+}
+
+func isJSONPath(mapping string) bool {
+	return mapping == "$" || strings.HasPrefix(mapping, "$.") || strings.HasPrefix(mapping, "$[")
+}
+
+// evalInbound evaluates a mapping, JSON paths keep the type of the selected value.
+func evalInbound(mapping string, data map[string]interface{}) (interface{}, error) {
+	if isJSONPath(mapping) {
+		value, _ := lookupJSONPath(data["body"], mapping)
+		return value, nil
+	}
+	return executeTemplate(mapping, data)
+}
+
+func evalInboundString(mapping string, data map[string]interface{}) (string, error) {
+	value, err := evalInbound(mapping, data)
+	if err != nil {
+		return "", err
+	}
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string, float64, bool:
+		return jsonValueString(v), nil
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+}
+
+// lookupJSONPath resolves a simple JSON path like $.alerts[0].labels.name.
+func lookupJSONPath(v interface{}, path string) (interface{}, bool) {
+	path = strings.TrimPrefix(path, "$")
+	for path != "" {
+		switch path[0] {
+		case '.':
+			path = path[1:]
+			end := strings.IndexAny(path, ".[")
+			if end < 0 {
+				end = len(path)
+			}
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if v, ok = m[path[:end]]; !ok {
+				return nil, false
+			}
+			path = path[end:]
+		case '[':
+			end := strings.IndexByte(path, ']')
+			if end < 0 {
+				return nil, false
+			}
+			index := path[1:end]
+			path = path[end+1:]
+			if key, err := strconv.Unquote(index); err == nil {
+				m, ok := v.(map[string]interface{})
+				if !ok {
+					return nil, false
+				}
+				if v, ok = m[key]; !ok {
+					return nil, false
+				}
+				continue
+			}
+			i, err := strconv.Atoi(index)
+			l, ok := v.([]interface{})
+			if err != nil || !ok || i < 0 || i >= len(l) {
+				return nil, false
+			}
+			v = l[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// RegisterWebhook implements plugin.Webhooker.
+func (p *MultiNotifierPlugin) RegisterWebhook(basePath string, mux *gin.RouterGroup) {
+	p.basePath = basePath
+	mux.POST("/inbound/:name", p.handleInbound)
+	mux.GET("/metrics", p.handleMetrics)
+	mux.GET("/history", p.handleHistory)
+	mux.POST("/webhooks/:name/test", p.handleTestWebhook)
+	mux.POST("/templates/preview", p.handlePreviewTemplate)
+}
+
+func (p *MultiNotifierPlugin) handleInbound(c *gin.Context) {
+	name := c.Param("name")
+	var route *InboundRoute
+	if config := p.currentConfig(); config != nil {
+		route = findInboundRoute(config.Inbound, name)
+	}
+	if route == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown inbound route"})
+		return
+	}
+
+	// The client ip honors the trusted proxies configured for the Gotify server.
+	if err := route.Auth.verifyClient(c.Request, c.ClientIP()); err != nil {
+		rejectInbound(c, name, err)
+		return
+	}
+
+	raw, err := io.ReadAll(io.LimitReader(c.Request.Body, maxInboundBody+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read body"})
+		return
+	}
+	if len(raw) > maxInboundBody {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "body too large"})
+		return
+	}
+
+	if err := route.Auth.verifyBody(c.Request, raw, time.Now(), &p.replays, name); err != nil {
+		rejectInbound(c, name, err)
+		return
+	}
+
+	// Requests relayed by webhooks of this or other plugin instances carry their hops.
+	relayID, err := p.relayID()
+	if err != nil {
+		logger.Error("Failed to check inbound request", slog.String("route", name), slog.Any("err", err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	relay := requestRelay(c.Request)
+	if reason := p.loopReason(relayID, relay); reason != "" {
+		logger.Warn("Rejected inbound request", slog.String("route", name), slog.String("client", c.ClientIP()), slog.String("reason", reason))
+		c.JSON(http.StatusLoopDetected, gin.H{"error": reason})
+		return
+	}
+
+	msg, err := route.message(c.Request, inboundData(c.Request, raw))
+	if err != nil {
+		logger.Warn("Failed to map inbound request", slog.String("route", name), slog.Any("err", err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if relay.Hops > 0 {
+		if msg.Extras == nil {
+			msg.Extras = make(map[string]interface{})
+		}
+		msg.Extras[relayExtra] = relay
+	}
+
+	if p.msgHandler == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "plugin is not ready"})
+		return
+	}
+	if err := p.msgHandler.SendMessage(*msg); err != nil {
+		logger.Error("Failed to send inbound message", slog.String("route", name), slog.Any("err", err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send message"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// rejectInbound responds to a request failing the route's auth.
+func rejectInbound(c *gin.Context, route string, err error) {
+	status := http.StatusUnauthorized
+	if authErr, ok := err.(*inboundAuthError); ok {
+		status = authErr.status
+	}
+	logger.Warn("Rejected inbound request", slog.String("route", route), slog.String("client", c.ClientIP()), slog.Any("reason", err))
+	if status == http.StatusUnauthorized {
+		c.Header("WWW-Authenticate", "Bearer")
+	}
+	c.JSON(status, gin.H{"error": err.Error()})
+}
+
+// authorizeToken checks the bearer token of a request to a route protected by token and responds
+// with 401 Unauthorized if it doesn't match. Without a token the route is disabled and responds
+// with 404 Not Found.
+func authorizeToken(c *gin.Context, token string) bool {
+	if token == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "route is disabled, no token is configured"})
+		return false
+	}
+	authorization := c.GetHeader("Authorization")
+	if strings.HasPrefix(authorization, "Bearer ") &&
+		subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(authorization, "Bearer ")), []byte(token)) == 1 {
+		return true
+	}
+	c.Header("WWW-Authenticate", "Bearer")
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+	return false
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"container/heap"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultInboundTolerance = 5 * time.Minute
+
+// Signature schemes of inbound requests.
+const (
+	SignatureGitHub = "github"
+	SignatureHMAC   = "hmac"
+)
+
+// InboundAuth restricts who may post to an inbound route. All configured checks must pass, at
+// least one is required unless Insecure is set.
+type InboundAuth struct {
+	// Insecure accepts requests from everyone if no check is configured.
+	Insecure bool `yaml:"insecure"`
+	// Token is a static token expected as `Authorization: Bearer <token>`.
+	Token string `yaml:"token"`
+	// AllowedIPs are the IP addresses or CIDR ranges requests may come from.
+	AllowedIPs []string `yaml:"allowed_ips"`
+
+	// Signature verifies an HMAC of the body with Secret, either `github` or `hmac`.
+	Signature string `yaml:"signature"`
+	Secret    string `yaml:"secret"`
+	// Header, Algorithm, Encoding and Prefix describe a generic `hmac` signature.
+	Header    string `yaml:"header"`
+	Algorithm string `yaml:"algorithm"`
+	Encoding  string `yaml:"encoding"`
+	Prefix    string `yaml:"prefix"`
+	// TimestampHeader holds the unix time the request was signed at, the signed content is then
+	// `<timestamp>.<body>`. Requests older than Tolerance and repeated signatures are rejected.
+	TimestampHeader string `yaml:"timestamp_header"`
+	// IDHeader holds a unique ID of each request, repeated IDs are rejected within Tolerance. It is
+	// X-GitHub-Delivery for github signatures, which carry no timestamp, so their replays are only
+	// rejected within Tolerance as well.
+	IDHeader  string   `yaml:"id_header"`
+	Tolerance Duration `yaml:"tolerance"`
+
+	networks []*net.IPNet
+	hash     func() hash.Hash
+}
+
+// replayGuard remembers the requests of the inbound routes until they can't be replayed anymore.
+// The plugin keeps it across reloads, so a new config doesn't accept replayed requests again.
+type replayGuard struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+	// expiries orders the recorded keys by their expiry, so expired keys are removed without
+	// scanning all of them.
+	expiries replayExpiries
+}
+
+// check records the key until expires, it returns false if the key is already recorded.
+func (g *replayGuard) check(key string, expires, now time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for len(g.expiries) > 0 && now.After(g.expiries[0].expires) {
+		delete(g.seen, heap.Pop(&g.expiries).(replayExpiry).key)
+	}
+	if _, ok := g.seen[key]; ok {
+		return false
+	}
+	if g.seen == nil {
+		g.seen = make(map[string]time.Time)
+	}
+	g.seen[key] = expires
+	heap.Push(&g.expiries, replayExpiry{key: key, expires: expires})
+	return true
+}
+
+type replayExpiry struct {
+	key     string
+	expires time.Time
+}
+
+// replayExpiries is a heap.Interface of recorded keys, the earliest expiry first.
+type replayExpiries []replayExpiry
+
+func (h replayExpiries) Len() int           { return len(h) }
+func (h replayExpiries) Less(i, j int) bool { return h[i].expires.Before(h[j].expires) }
+func (h replayExpiries) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *replayExpiries) Push(x interface{}) {
+	*h = append(*h, x.(replayExpiry))
+}
+
+func (h *replayExpiries) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// inboundAuthError is a rejected inbound request with the status code to respond with.
+type inboundAuthError struct {
+	status int
+	reason string
+}
+
+func (e *inboundAuthError) Error() string {
+	return e.reason
+}
+
+func unauthorized(format string, a ...interface{}) error {
+	return &inboundAuthError{status: http.StatusUnauthorized, reason: fmt.Sprintf(format, a...)}
+}
+
+func (a *InboundAuth) compile() error {
+	if a == nil {
+		return nil
+	}
+
+	a.networks = nil
+	for _, allowed := range a.AllowedIPs {
+		if !strings.Contains(allowed, "/") {
+			ip := net.ParseIP(allowed)
+			if ip == nil {
+				return fmt.Errorf("invalid allowed ip %q", allowed)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			a.networks = append(a.networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(allowed)
+		if err != nil {
+			return fmt.Errorf("invalid allowed ip %q", allowed)
+		}
+		a.networks = append(a.networks, network)
+	}
+
+	switch a.Signature {
+	case "":
+		if a.TimestampHeader != "" || a.IDHeader != "" {
+			return fmt.Errorf("timestamp_header and id_header require a signature")
+		}
+		if a.open() && !a.Insecure {
+			return fmt.Errorf("token, allowed_ips or signature is required, set insecure to accept requests from everyone")
+		}
+		return nil
+	case SignatureGitHub:
+		a.Header, a.Algorithm, a.Encoding, a.Prefix = "X-Hub-Signature-256", "sha256", "hex", "sha256="
+		if a.IDHeader == "" {
+			a.IDHeader = "X-GitHub-Delivery"
+		}
+	case SignatureHMAC:
+		if a.Header == "" {
+			a.Header = "X-Signature"
+		}
+	default:
+		return fmt.Errorf("unknown signature %q", a.Signature)
+	}
+	if a.Secret == "" {
+		return fmt.Errorf("secret is required for signatures")
+	}
+
+	var err error
+	if a.hash, err = hmacHash(a.Algorithm); err != nil {
+		return err
+	}
+	switch a.Encoding {
+	case "":
+		a.Encoding = "hex"
+	case "hex", "base64":
+	default:
+		return fmt.Errorf("unknown encoding %q", a.Encoding)
+	}
+	if a.Tolerance <= 0 {
+		a.Tolerance = Duration(defaultInboundTolerance)
+	}
+	return nil
+}
+
+// open reports whether no check is configured, so everyone may post to the route.
+func (a *InboundAuth) open() bool {
+	return a.Token == "" && len(a.networks) == 0 && a.Signature == ""
+}
+
+// hmacHash returns the hash function of an HMAC algorithm, sha256 by default.
+func hmacHash(algorithm string) (func() hash.Hash, error) {
+	switch algorithm {
+	case "", "sha256":
+		return sha256.New, nil
+	case "sha512":
+		return sha512.New, nil
+	default:
+		return nil, fmt.Errorf("unknown algorithm %q", algorithm)
+	}
+}
+
+// verifyClient checks the client address and token before the body is read.
+func (a *InboundAuth) verifyClient(req *http.Request, clientIP string) error {
+	if a == nil {
+		return nil
+	}
+
+	if len(a.networks) > 0 {
+		ip := net.ParseIP(clientIP)
+		allowed := false
+		for _, network := range a.networks {
+			allowed = allowed || ip != nil && network.Contains(ip)
+		}
+		if !allowed {
+			return &inboundAuthError{status: http.StatusForbidden, reason: "client ip is not allowed"}
+		}
+	}
+
+	if a.Token != "" {
+		authorization := req.Header.Get("Authorization")
+		if !strings.HasPrefix(authorization, "Bearer ") {
+			return unauthorized("missing bearer token")
+		}
+		token := strings.TrimPrefix(authorization, "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(a.Token)) != 1 {
+			return unauthorized("invalid bearer token")
+		}
+	}
+	return nil
+}
+
+// verifyBody checks the signature of the body and rejects stale requests, as well as requests of
+// the route already recorded by replays.
+func (a *InboundAuth) verifyBody(req *http.Request, raw []byte, now time.Time, replays *replayGuard, route string) error {
+	if a == nil || a.Signature == "" {
+		return nil
+	}
+
+	header := req.Header.Get(a.Header)
+	if header == "" {
+		return unauthorized("missing signature header %s", a.Header)
+	}
+	if !strings.HasPrefix(header, a.Prefix) {
+		return unauthorized("malformed signature")
+	}
+	encoded := strings.TrimPrefix(header, a.Prefix)
+	var (
+		signature []byte
+		err       error
+	)
+	if a.Encoding == "base64" {
+		signature, err = base64.StdEncoding.DecodeString(encoded)
+	} else {
+		signature, err = hex.DecodeString(encoded)
+	}
+	if err != nil {
+		return unauthorized("malformed signature")
+	}
+
+	mac := hmac.New(a.hash, []byte(a.Secret))
+	var signed time.Time
+	if a.TimestampHeader != "" {
+		timestamp := req.Header.Get(a.TimestampHeader)
+		seconds, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return unauthorized("missing or malformed timestamp header %s", a.TimestampHeader)
+		}
+		signed = time.Unix(seconds, 0)
+		mac.Write([]byte(timestamp + "."))
+	}
+	mac.Write(raw)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return unauthorized("invalid signature")
+	}
+
+	tolerance := time.Duration(a.Tolerance)
+	replayed := &inboundAuthError{status: http.StatusConflict, reason: "request was already received"}
+	if a.TimestampHeader != "" {
+		if d := now.Sub(signed); d > tolerance || d < -tolerance {
+			return unauthorized("timestamp is outside of the tolerance")
+		}
+		// The signature can't be replayed once its timestamp is out of the tolerance.
+		if !replays.check(route+"\x00signature\x00"+string(signature), signed.Add(tolerance), now) {
+			return replayed
+		}
+	}
+	if a.IDHeader != "" {
+		id := req.Header.Get(a.IDHeader)
+		if id == "" {
+			return unauthorized("missing id header %s", a.IDHeader)
+		}
+		if !replays.check(route+"\x00id\x00"+id, now.Add(tolerance), now) {
+			return replayed
+		}
+	}
+	return nil
+}
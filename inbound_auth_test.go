@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInboundAuthCompile(t *testing.T) {
+	assert.NoError(t, (&InboundAuth{AllowedIPs: []string{"10.0.0.1", "192.168.0.0/16", "::1"}}).compile())
+	assert.Error(t, (&InboundAuth{AllowedIPs: []string{"10.0.0"}}).compile())
+	assert.Error(t, (&InboundAuth{Signature: "github"}).compile())
+	assert.Error(t, (&InboundAuth{Signature: "rsa", Secret: "s"}).compile())
+	assert.Error(t, (&InboundAuth{Signature: "hmac", Secret: "s", Algorithm: "md5"}).compile())
+	assert.Error(t, (&InboundAuth{Signature: "hmac", Secret: "s", Encoding: "base32"}).compile())
+	assert.Error(t, (&InboundAuth{TimestampHeader: "X-Timestamp"}).compile())
+	assert.Error(t, (&InboundAuth{Token: "t", IDHeader: "X-Request-Id"}).compile())
+	assert.Error(t, (&InboundAuth{}).compile())
+	assert.NoError(t, (&InboundAuth{Insecure: true}).compile())
+
+	auth := &InboundAuth{Signature: "hmac", Secret: "s"}
+	assert.NoError(t, auth.compile())
+	assert.Equal(t, "X-Signature", auth.Header)
+	assert.Equal(t, "hex", auth.Encoding)
+}
+
+func TestReplayGuard(t *testing.T) {
+	g := &replayGuard{}
+	now := time.Now()
+	assert.True(t, g.check("late", now.Add(time.Hour), now))
+	assert.True(t, g.check("early", now.Add(time.Minute), now))
+	assert.False(t, g.check("late", now.Add(time.Hour), now))
+	assert.False(t, g.check("early", now.Add(time.Minute), now))
+
+	// Expired keys are forgotten in the order of their expiry.
+	now = now.Add(2 * time.Minute)
+	assert.True(t, g.check("early", now.Add(time.Minute), now))
+	assert.False(t, g.check("late", now.Add(time.Hour), now))
+	assert.Len(t, g.seen, 2)
+	assert.Len(t, g.expiries, 2)
+
+	now = now.Add(2 * time.Hour)
+	assert.True(t, g.check("late", now.Add(time.Hour), now))
+	assert.Len(t, g.seen, 1)
+	assert.Len(t, g.expiries, 1)
+}
+
+func TestHandleInboundAuth(t *testing.T) {
+	handler := &recordingMessageHandler{}
+	p := &MultiNotifierPlugin{msgHandler: handler, config: &Config{Inbound: []*InboundRoute{
+		{Name: "github", Message: "$.zen", Auth: &InboundAuth{Signature: "github", Secret: "hush"}},
+		{Name: "token", Message: "$.text", Auth: &InboundAuth{Token: "t0ken", AllowedIPs: []string{"192.0.2.0/24"}}},
+		{Name: "signed", Message: "$.text", Auth: &InboundAuth{
+			Signature: "hmac", Secret: "hush", Algorithm: "sha512", Encoding: "base64",
+			Header: "X-Sig", TimestampHeader: "X-Timestamp",
+		}},
+	}}}
+	for _, route := range p.config.Inbound {
+		assert.NoError(t, route.compile())
+	}
+	router := newTestRouter(p)
+
+	post := func(route, body string, headers map[string]string) int {
+		req := httptest.NewRequest(http.MethodPost, "/plugin/1/custom/xyz/inbound/"+route, strings.NewReader(body))
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	body := `{"zen": "Keep it simple."}`
+	mac := hmac.New(sha256.New, []byte("hush"))
+	mac.Write([]byte(body))
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	delivery := map[string]string{"X-Hub-Signature-256": signature, "X-GitHub-Delivery": "d1"}
+	assert.Equal(t, http.StatusOK, post("github", body, delivery))
+	assert.Equal(t, http.StatusConflict, post("github", body, delivery))
+	assert.Equal(t, http.StatusUnauthorized, post("github", body, map[string]string{"X-Hub-Signature-256": signature}))
+	assert.Equal(t, http.StatusUnauthorized, post("github", body, nil))
+	assert.Equal(t, http.StatusUnauthorized, post("github", `{"zen": "tampered"}`, map[string]string{"X-Hub-Signature-256": signature}))
+	assert.Equal(t, http.StatusUnauthorized, post("github", body, map[string]string{"X-Hub-Signature-256": "sha256=zz"}))
+
+	// httptest requests come from 192.0.2.1.
+	assert.Equal(t, http.StatusOK, post("token", `{"text": "hi"}`, map[string]string{"Authorization": "Bearer t0ken"}))
+	assert.Equal(t, http.StatusUnauthorized, post("token", `{"text": "hi"}`, map[string]string{"Authorization": "Bearer wrong"}))
+	assert.Equal(t, http.StatusUnauthorized, post("token", `{"text": "hi"}`, nil))
+	p.config.Inbound[1].Auth.AllowedIPs = []string{"10.0.0.0/8"}
+	assert.NoError(t, p.config.Inbound[1].compile())
+	assert.Equal(t, http.StatusForbidden, post("token", `{"text": "hi"}`, map[string]string{"Authorization": "Bearer t0ken"}))
+
+	sign := func(timestamp time.Time, body string) map[string]string {
+		ts := strconv.FormatInt(timestamp.Unix(), 10)
+		mac := hmac.New(sha512.New, []byte("hush"))
+		mac.Write([]byte(ts + "." + body))
+		return map[string]string{"X-Timestamp": ts, "X-Sig": base64.StdEncoding.EncodeToString(mac.Sum(nil))}
+	}
+	headers := sign(time.Now(), `{"text": "signed"}`)
+	assert.Equal(t, http.StatusOK, post("signed", `{"text": "signed"}`, headers))
+	assert.Equal(t, http.StatusConflict, post("signed", `{"text": "signed"}`, headers))
+	assert.Equal(t, http.StatusUnauthorized, post("signed", `{"text": "old"}`, sign(time.Now().Add(-time.Hour), `{"text": "old"}`)))
+
+	// Reloading the config doesn't forget the received requests.
+	p.config = &Config{Inbound: []*InboundRoute{
+		{Name: "github", Message: "$.zen", Auth: &InboundAuth{Signature: "github", Secret: "hush"}},
+		p.config.Inbound[1], p.config.Inbound[2],
+	}}
+	assert.NoError(t, p.config.Inbound[0].compile())
+	assert.Equal(t, http.StatusConflict, post("github", body, delivery))
+	assert.Equal(t, http.StatusConflict, post("signed", `{"text": "signed"}`, headers))
+
+	headers = sign(time.Now(), `{"text": "signed"}`)
+	headers["X-Timestamp"] = "1"
+	assert.Equal(t, http.StatusUnauthorized, post("signed", `{"text": "signed"}`, headers))
+
+	sent := handler.sent()
+	if assert.Len(t, sent, 3) {
+		assert.Equal(t, "Keep it simple.", sent[0].Message)
+		assert.Equal(t, "hi", sent[1].Message)
+		assert.Equal(t, "signed", sent[2].Message)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gotify/plugin-api"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingMessageHandler is a plugin.MessageHandler recording the sent messages.
+type recordingMessageHandler struct {
+	mu       sync.Mutex
+	messages []plugin.Message
+}
+
+func (h *recordingMessageHandler) SendMessage(msg plugin.Message) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.messages = append(h.messages, msg)
+	return nil
+}
+
+func (h *recordingMessageHandler) sent() []plugin.Message {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]plugin.Message(nil), h.messages...)
+}
+
+// newTestRouter registers the plugin's webhooks on a gin router.
+func newTestRouter(p *MultiNotifierPlugin) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	p.RegisterWebhook("/plugin/1/custom/xyz", router.Group("/plugin/1/custom/xyz"))
+	return router
+}
+
+func TestLookupJSONPath(t *testing.T) {
+	v := map[string]interface{}{
+		"alerts": []interface{}{
+			map[string]interface{}{"labels": map[string]interface{}{"alertname": "DiskFull", "a.b": "dotted"}},
+		},
+	}
+
+	value, ok := lookupJSONPath(v, "$.alerts[0].labels.alertname")
+	assert.True(t, ok)
+	assert.Equal(t, "DiskFull", value)
+
+	value, ok = lookupJSONPath(v, `$.alerts[0].labels["a.b"]`)
+	assert.True(t, ok)
+	assert.Equal(t, "dotted", value)
+
+	_, ok = lookupJSONPath(v, "$.alerts[1].labels")
+	assert.False(t, ok)
+	_, ok = lookupJSONPath(v, "$.missing")
+	assert.False(t, ok)
+}
+
+func TestInboundRouteCompile(t *testing.T) {
+	assert.Error(t, (&InboundRoute{Name: "a/b", Message: "x"}).compile())
+	assert.Error(t, (&InboundRoute{Name: "ci"}).compile())
+	assert.Error(t, (&InboundRoute{Name: "ci", Message: "{{.body"}).compile())
+	insecure := &InboundAuth{Insecure: true}
+	assert.NoError(t, (&InboundRoute{Name: "ci", Message: "$.text", Title: "{{.query.title}}", Auth: insecure}).compile())
+
+	// Routes must verify their requests unless they explicitly accept everyone.
+	assert.Error(t, (&InboundRoute{Name: "ci", Message: "$.text"}).compile())
+	assert.Error(t, (&InboundRoute{Name: "ci", Message: "$.text", Auth: &InboundAuth{}}).compile())
+}
+
+func TestHandleInbound(t *testing.T) {
+	handler := &recordingMessageHandler{}
+	p := &MultiNotifierPlugin{config: &Config{Inbound: []*InboundRoute{
+		{
+			Name:     "ci",
+			Title:    "{{.body.project}} build {{.body.status}}",
+			Message:  "$.commit.message",
+			Priority: `{{if eq .body.status "failed"}}8{{else}}2{{end}}`,
+			Extras: map[string]string{
+				"client::notification": "$.notification",
+				"source":               `{{index .headers "User-Agent"}}`,
+			},
+		},
+		{Name: "form", Message: "{{.body.text}}", Title: "{{.query.title}}"},
+	}}}
+	p.SetMessageHandler(handler)
+	router := newTestRouter(p)
+
+	post := func(path, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/plugin/1/custom/xyz"+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("User-Agent", "ci-bot")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post("/inbound/ci", "application/json", `{"project": "api", "status": "failed", "commit": {"message": "Fix tests"}, "notification": {"click": {"url": "http://ci/1"}}}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = post("/inbound/form?title=Hello", "application/x-www-form-urlencoded", "text=a+b%26c")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = post("/inbound/ci", "application/json", `{"project": "api", "status": "failed", "commit": {"message": "x"}}`+strings.Repeat(" ", maxInboundBody))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	w = post("/inbound/unknown", "application/json", `{}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	sent := handler.sent()
+	if assert.Len(t, sent, 2) {
+		assert.Equal(t, "api build failed", sent[0].Title)
+		assert.Equal(t, "Fix tests", sent[0].Message)
+		assert.Equal(t, 8, sent[0].Priority)
+		assert.Equal(t, "ci-bot", sent[0].Extras["source"])
+		assert.Equal(t, map[string]interface{}{"click": map[string]interface{}{"url": "http://ci/1"}}, sent[0].Extras["client::notification"])
+
+		assert.Equal(t, "Hello", sent[1].Title)
+		assert.Equal(t, "a b&c", sent[1].Message)
+	}
+}
+
+func TestHandleInboundInvalidPriority(t *testing.T) {
+	p := &MultiNotifierPlugin{config: &Config{Inbound: []*InboundRoute{{Name: "x", Message: "m", Priority: "$.level"}}}}
+	p.SetMessageHandler(&recordingMessageHandler{})
+	router := newTestRouter(p)
+
+	req := httptest.NewRequest(http.MethodPost, "/plugin/1/custom/xyz/inbound/x", strings.NewReader(`{"level": "high"}`))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// latencyBuckets are the upper bounds in seconds of the delivery latency histogram.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// histogram counts observations in latencyBuckets.
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (h *histogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(latencyBuckets))
+	}
+	for i, bound := range latencyBuckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// metricsWriter writes metrics in the Prometheus text exposition format.
+type metricsWriter struct {
+	b strings.Builder
+}
+
+func (w *metricsWriter) header(name, typ, help string) {
+	fmt.Fprintf(&w.b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample writes a sample, labels are pairs of names and values.
+func (w *metricsWriter) sample(name string, value float64, labels ...string) {
+	w.b.WriteString(name)
+	if len(labels) > 0 {
+		w.b.WriteByte('{')
+		for i := 0; i < len(labels); i += 2 {
+			if i > 0 {
+				w.b.WriteByte(',')
+			}
+			fmt.Fprintf(&w.b, "%s=\"%s\"", labels[i], escapeLabelValue(labels[i+1]))
+		}
+		w.b.WriteByte('}')
+	}
+	w.b.WriteByte(' ')
+	w.b.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	w.b.WriteByte('\n')
+}
+
+func escapeLabelValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// webhookLabel identifies the webhook in metrics and notifications. Unnamed webhooks repeating a
+// URL are told apart by the occurrence their key ends with, e.g. `https://example.com #2`.
+func webhookLabel(webhook *WebHook) string {
+	if webhook.Name != "" {
+		return webhook.Name
+	}
+	label := displayURL(webhook.Url)
+	key := webhookKey(webhook)
+	if i := strings.LastIndex(key, "-"); i > len("url-") {
+		label += " #" + key[i+1:]
+	}
+	return label
+}
+
+// writeMetrics writes all metrics of the plugin.
+func (p *MultiNotifierPlugin) writeMetrics(w *metricsWriter) {
+	messages, stats := p.stats.snapshot()
+	histograms := p.stats.latencies()
+	var webhooks []*WebHook
+	if config := p.currentConfig(); config != nil {
+		webhooks = config.WebHooks
+	}
+
+	w.header("gotify_webhook_messages_received_total", "counter", "Messages received from the Gotify stream.")
+	w.sample("gotify_webhook_messages_received_total", float64(messages))
+
+	w.header("gotify_webhook_deliveries_total", "counter", "Deliveries to webhooks by outcome.")
+	for _, webhook := range webhooks {
+		s := stats[webhookKey(webhook)]
+		if s == nil {
+			s = &WebhookStats{}
+		}
+		w.sample("gotify_webhook_deliveries_total", float64(s.Succeeded), "webhook", webhookLabel(webhook), "outcome", DeliverySucceeded)
+		w.sample("gotify_webhook_deliveries_total", float64(s.Failed), "webhook", webhookLabel(webhook), "outcome", DeliveryFailed)
+	}
+
+	counters := []struct {
+		name, help string
+		value      func(s *WebhookStats) int64
+	}{
+		{"gotify_webhook_delivery_retries_total", "Repeated delivery attempts.", func(s *WebhookStats) int64 { return s.Retried }},
+		{"gotify_webhook_messages_filtered_total", "Messages not matching the webhook's apps, filter or when expression.", func(s *WebhookStats) int64 { return s.Filtered }},
+		{"gotify_webhook_messages_throttled_total", "Messages suppressed as duplicates or dropped outside of the schedule.", func(s *WebhookStats) int64 { return s.Throttled }},
+	}
+	for _, counter := range counters {
+		w.header(counter.name, "counter", counter.help)
+		for _, webhook := range webhooks {
+			var value int64
+			if s := stats[webhookKey(webhook)]; s != nil {
+				value = counter.value(s)
+			}
+			w.sample(counter.name, float64(value), "webhook", webhookLabel(webhook))
+		}
+	}
+
+	w.header("gotify_webhook_delivery_duration_seconds", "histogram", "Duration of deliveries including retries.")
+	for _, webhook := range webhooks {
+		label := webhookLabel(webhook)
+		h := histograms[webhookKey(webhook)]
+		if h == nil {
+			h = &histogram{}
+		}
+		for i, bound := range latencyBuckets {
+			var count uint64
+			if h.counts != nil {
+				count = h.counts[i]
+			}
+			w.sample("gotify_webhook_delivery_duration_seconds_bucket", float64(count), "webhook", label, "le", strconv.FormatFloat(bound, 'g', -1, 64))
+		}
+		w.sample("gotify_webhook_delivery_duration_seconds_bucket", float64(h.count), "webhook", label, "le", "+Inf")
+		w.sample("gotify_webhook_delivery_duration_seconds_sum", h.sum, "webhook", label)
+		w.sample("gotify_webhook_delivery_duration_seconds_count", float64(h.count), "webhook", label)
+	}
+
+	w.header("gotify_webhook_queue_depth", "gauge", "Messages waiting in batches or for their schedule window.")
+	for _, webhook := range webhooks {
+		w.sample("gotify_webhook_queue_depth", float64(queueDepth(webhook)), "webhook", webhookLabel(webhook))
+	}
+
+	p.stream.mu.Lock()
+	up := 0.0
+	if p.stream.connected {
+		up = 1
+	}
+	p.stream.mu.Unlock()
+	w.header("gotify_webhook_stream_reconnects_total", "counter", "Reconnects to the Gotify stream.")
+	w.sample("gotify_webhook_stream_reconnects_total", float64(p.stream.reconnects()))
+	w.header("gotify_webhook_stream_up", "gauge", "Whether the plugin is connected to the Gotify stream.")
+	w.sample("gotify_webhook_stream_up", up)
+}
+
+func (p *MultiNotifierPlugin) handleMetrics(c *gin.Context) {
+	config := p.currentConfig()
+	if config == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "plugin is not configured"})
+		return
+	}
+	if !authorizeToken(c, config.MetricsToken) {
+		return
+	}
+
+	w := &metricsWriter{}
+	p.writeMetrics(w)
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(w.b.String()))
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gotify/plugin-api"
+)
+
+// inboundParser turns the payload of a well-known webhook format into a Gotify message.
+type inboundParser func(req *http.Request, body interface{}) (*plugin.Message, error)
+
+// inboundParsers are the built-in parsers available as InboundRoute.Parser.
+var inboundParsers = map[string]inboundParser{
+	"alertmanager": parseAlertmanager,
+	"grafana":      parseGrafana,
+	"github":       parseGitHub,
+	"gitlab":       parseGitLab,
+	"uptime-kuma":  parseUptimeKuma,
+}
+
+// Priorities of parsed messages.
+const (
+	priorityResolved = 2
+	priorityInfo     = 4
+	priorityWarning  = 5
+	priorityCritical = 8
+)
+
+// jsonString returns the value at the JSON path as string, or an empty string.
+func jsonString(v interface{}, path string) string {
+	value, ok := lookupJSONPath(v, path)
+	if !ok || value == nil {
+		return ""
+	}
+	return jsonValueString(value)
+}
+
+// jsonValueString formats a scalar JSON value, numbers are never formatted with exponents.
+func jsonValueString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func jsonSlice(v interface{}, path string) []interface{} {
+	value, _ := lookupJSONPath(v, path)
+	l, _ := value.([]interface{})
+	return l
+}
+
+// markdownMessage returns a message displayed as markdown, opening clickURL when clicked.
+func markdownMessage(title, message string, priority int, clickURL string) *plugin.Message {
+	extras := map[string]interface{}{
+		"client::display": map[string]interface{}{"contentType": "text/markdown"},
+	}
+	if clickURL != "" {
+		extras["client::notification"] = map[string]interface{}{"click": map[string]interface{}{"url": clickURL}}
+	}
+	return &plugin.Message{Title: title, Message: message, Priority: priority, Extras: extras}
+}
+
+func severityPriority(severity string) int {
+	switch strings.ToLower(severity) {
+	case "critical", "error", "page", "high":
+		return priorityCritical
+	case "info", "low", "none":
+		return priorityInfo
+	default:
+		return priorityWarning
+	}
+}
+
+// parseAlertmanager parses grouped alerts of the Prometheus Alertmanager webhook receiver.
+func parseAlertmanager(req *http.Request, body interface{}) (*plugin.Message, error) {
+	alerts := jsonSlice(body, "$.alerts")
+	if alerts == nil {
+		return nil, fmt.Errorf("not an alertmanager payload")
+	}
+
+	status := jsonString(body, "$.status")
+	name := jsonString(body, "$.groupLabels.alertname")
+	if name == "" {
+		name = jsonString(body, "$.commonLabels.alertname")
+	}
+	if name == "" {
+		name = jsonString(body, "$.receiver")
+	}
+
+	firing := 0
+	priority := priorityResolved
+	var lines []string
+	for _, alert := range alerts {
+		alertStatus := jsonString(alert, "$.status")
+		if alertStatus == "firing" {
+			firing++
+			if p := severityPriority(jsonString(alert, "$.labels.severity")); p > priority {
+				priority = p
+			}
+		}
+
+		summary := jsonString(alert, "$.annotations.summary")
+		if summary == "" {
+			summary = jsonString(alert, "$.annotations.description")
+		}
+		if summary == "" {
+			summary = jsonString(alert, "$.labels.alertname")
+		}
+		line := fmt.Sprintf("- **%s** %s", strings.ToUpper(alertStatus), summary)
+		if labels := formatLabels(alert, "alertname", "severity"); labels != "" {
+			line += " (" + labels + ")"
+		}
+		lines = append(lines, line)
+	}
+
+	var title string
+	if status == "resolved" {
+		title = fmt.Sprintf("[RESOLVED] %s", name)
+	} else {
+		title = fmt.Sprintf("[FIRING:%d] %s", firing, name)
+	}
+
+	return markdownMessage(title, strings.Join(lines, "\n"), priority, jsonString(body, "$.externalURL")), nil
+}
+
+// formatLabels formats the labels of an alert except the excluded ones, sorted by name.
+func formatLabels(alert interface{}, exclude ...string) string {
+	value, _ := lookupJSONPath(alert, "$.labels")
+	labels, _ := value.(map[string]interface{})
+
+	var pairs []string
+	for k, v := range labels {
+		excluded := false
+		for _, e := range exclude {
+			excluded = excluded || k == e
+		}
+		if !excluded {
+			pairs = append(pairs, fmt.Sprintf("%s=%v", k, v))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ", ")
+}
+
+// parseGrafana parses Grafana alerting webhooks, both unified and legacy alerting.
+func parseGrafana(req *http.Request, body interface{}) (*plugin.Message, error) {
+	if jsonSlice(body, "$.alerts") != nil {
+		msg, err := parseAlertmanager(req, body)
+		if err != nil {
+			return nil, err
+		}
+		if title := jsonString(body, "$.title"); title != "" {
+			msg.Title = title
+		}
+		if message := jsonString(body, "$.message"); message != "" {
+			msg.Message = message
+		}
+		return msg, nil
+	}
+
+	state := jsonString(body, "$.state")
+	if state == "" {
+		return nil, fmt.Errorf("not a grafana payload")
+	}
+
+	var priority int
+	switch state {
+	case "alerting":
+		priority = priorityCritical
+	case "ok":
+		priority = priorityResolved
+	default:
+		priority = priorityWarning
+	}
+
+	title := jsonString(body, "$.title")
+	if title == "" {
+		title = fmt.Sprintf("[%s] %s", strings.ToUpper(state), jsonString(body, "$.ruleName"))
+	}
+
+	lines := []string{jsonString(body, "$.message")}
+	for _, match := range jsonSlice(body, "$.evalMatches") {
+		lines = append(lines, fmt.Sprintf("- %s: %s", jsonString(match, "$.metric"), jsonString(match, "$.value")))
+	}
+	if image := jsonString(body, "$.imageUrl"); image != "" {
+		lines = append(lines, fmt.Sprintf("![graph](%s)", image))
+	}
+
+	return markdownMessage(title, strings.TrimSpace(strings.Join(lines, "\n")), priority, jsonString(body, "$.ruleUrl")), nil
+}
+
+// parseGitHub parses GitHub webhook events, identified by the X-GitHub-Event header.
+func parseGitHub(req *http.Request, body interface{}) (*plugin.Message, error) {
+	event := req.Header.Get("X-GitHub-Event")
+	if event == "" {
+		return nil, fmt.Errorf("missing X-GitHub-Event header")
+	}
+	repo := jsonString(body, "$.repository.full_name")
+	sender := jsonString(body, "$.sender.login")
+
+	switch event {
+	case "ping":
+		return markdownMessage(fmt.Sprintf("[%s] Webhook configured", repo), jsonString(body, "$.zen"), priorityInfo, ""), nil
+
+	case "push":
+		branch := strings.TrimPrefix(jsonString(body, "$.ref"), "refs/heads/")
+		commits := jsonSlice(body, "$.commits")
+		lines := make([]string, 0, len(commits))
+		for _, commit := range commits {
+			id := jsonString(commit, "$.id")
+			if len(id) > 7 {
+				id = id[:7]
+			}
+			message := strings.SplitN(jsonString(commit, "$.message"), "\n", 2)[0]
+			lines = append(lines, fmt.Sprintf("- [`%s`](%s) %s", id, jsonString(commit, "$.url"), message))
+		}
+		title := fmt.Sprintf("[%s] %s pushed %d commit(s) to %s", repo, sender, len(commits), branch)
+		return markdownMessage(title, strings.Join(lines, "\n"), priorityInfo, jsonString(body, "$.compare")), nil
+
+	case "pull_request":
+		action := jsonString(body, "$.action")
+		if action == "closed" && jsonString(body, "$.pull_request.merged") == "true" {
+			action = "merged"
+		}
+		title := fmt.Sprintf("[%s] Pull request #%s %s by %s", repo, jsonString(body, "$.number"), action, sender)
+		message := fmt.Sprintf("**%s**\n\n%s", jsonString(body, "$.pull_request.title"), jsonString(body, "$.pull_request.body"))
+		return markdownMessage(title, strings.TrimSpace(message), priorityInfo, jsonString(body, "$.pull_request.html_url")), nil
+
+	case "issues":
+		title := fmt.Sprintf("[%s] Issue #%s %s by %s", repo, jsonString(body, "$.issue.number"), jsonString(body, "$.action"), sender)
+		message := fmt.Sprintf("**%s**\n\n%s", jsonString(body, "$.issue.title"), jsonString(body, "$.issue.body"))
+		return markdownMessage(title, strings.TrimSpace(message), priorityInfo, jsonString(body, "$.issue.html_url")), nil
+
+	case "release":
+		title := fmt.Sprintf("[%s] Release %s %s", repo, jsonString(body, "$.release.tag_name"), jsonString(body, "$.action"))
+		return markdownMessage(title, jsonString(body, "$.release.body"), priorityInfo, jsonString(body, "$.release.html_url")), nil
+
+	case "workflow_run":
+		run := "$.workflow_run"
+		conclusion := jsonString(body, run+".conclusion")
+		if conclusion == "" {
+			conclusion = jsonString(body, run+".status")
+		}
+		priority := priorityInfo
+		if conclusion == "failure" || conclusion == "timed_out" {
+			priority = priorityCritical
+		}
+		title := fmt.Sprintf("[%s] Workflow %s %s", repo, jsonString(body, run+".name"), conclusion)
+		message := fmt.Sprintf("Branch %s, commit %s", jsonString(body, run+".head_branch"), jsonString(body, run+".head_commit.message"))
+		return markdownMessage(title, message, priority, jsonString(body, run+".html_url")), nil
+
+	default:
+		title := fmt.Sprintf("[%s] %s", repo, event)
+		if action := jsonString(body, "$.action"); action != "" {
+			title += " " + action
+		}
+		return markdownMessage(title, fmt.Sprintf("Triggered by %s", sender), priorityInfo, jsonString(body, "$.repository.html_url")), nil
+	}
+}
+
+// parseGitLab parses GitLab webhook events, identified by their object_kind.
+func parseGitLab(req *http.Request, body interface{}) (*plugin.Message, error) {
+	kind := jsonString(body, "$.object_kind")
+	if kind == "" {
+		return nil, fmt.Errorf("not a gitlab payload")
+	}
+	project := jsonString(body, "$.project.path_with_namespace")
+	user := jsonString(body, "$.user.name")
+	if user == "" {
+		user = jsonString(body, "$.user_name")
+	}
+
+	switch kind {
+	case "push", "tag_push":
+		ref := jsonString(body, "$.ref")
+		lines := []string{}
+		for _, commit := range jsonSlice(body, "$.commits") {
+			id := jsonString(commit, "$.id")
+			if len(id) > 8 {
+				id = id[:8]
+			}
+			message := strings.SplitN(jsonString(commit, "$.message"), "\n", 2)[0]
+			lines = append(lines, fmt.Sprintf("- [`%s`](%s) %s", id, jsonString(commit, "$.url"), message))
+		}
+		var title string
+		if kind == "tag_push" {
+			title = fmt.Sprintf("[%s] %s pushed tag %s", project, user, strings.TrimPrefix(ref, "refs/tags/"))
+		} else {
+			title = fmt.Sprintf("[%s] %s pushed %s commit(s) to %s", project, user, jsonString(body, "$.total_commits_count"), strings.TrimPrefix(ref, "refs/heads/"))
+		}
+		return markdownMessage(title, strings.Join(lines, "\n"), priorityInfo, jsonString(body, "$.project.web_url")), nil
+
+	case "merge_request":
+		attrs := "$.object_attributes"
+		title := fmt.Sprintf("[%s] Merge request !%s %s by %s", project, jsonString(body, attrs+".iid"), jsonString(body, attrs+".action"), user)
+		message := fmt.Sprintf("**%s**\n\n%s", jsonString(body, attrs+".title"), jsonString(body, attrs+".description"))
+		return markdownMessage(title, strings.TrimSpace(message), priorityInfo, jsonString(body, attrs+".url")), nil
+
+	case "issue":
+		attrs := "$.object_attributes"
+		title := fmt.Sprintf("[%s] Issue #%s %s by %s", project, jsonString(body, attrs+".iid"), jsonString(body, attrs+".action"), user)
+		message := fmt.Sprintf("**%s**\n\n%s", jsonString(body, attrs+".title"), jsonString(body, attrs+".description"))
+		return markdownMessage(title, strings.TrimSpace(message), priorityInfo, jsonString(body, attrs+".url")), nil
+
+	case "pipeline":
+		attrs := "$.object_attributes"
+		status := jsonString(body, attrs+".status")
+		priority := priorityInfo
+		switch status {
+		case "failed":
+			priority = priorityCritical
+		case "success":
+			priority = priorityResolved
+		}
+		title := fmt.Sprintf("[%s] Pipeline #%s %s", project, jsonString(body, attrs+".id"), status)
+		message := fmt.Sprintf("Ref %s, commit %s", jsonString(body, attrs+".ref"), jsonString(body, "$.commit.message"))
+		url := jsonString(body, attrs+".url")
+		if url == "" {
+			url = fmt.Sprintf("%s/-/pipelines/%s", jsonString(body, "$.project.web_url"), jsonString(body, attrs+".id"))
+		}
+		return markdownMessage(title, strings.TrimSpace(message), priority, url), nil
+
+	default:
+		title := fmt.Sprintf("[%s] %s", project, kind)
+		return markdownMessage(title, fmt.Sprintf("Triggered by %s", user), priorityInfo, jsonString(body, "$.project.web_url")), nil
+	}
+}
+
+// parseUptimeKuma parses Uptime Kuma webhook notifications.
+func parseUptimeKuma(req *http.Request, body interface{}) (*plugin.Message, error) {
+	msg := jsonString(body, "$.msg")
+	if msg == "" {
+		return nil, fmt.Errorf("not an uptime kuma payload")
+	}
+
+	// Test notifications don't have a heartbeat.
+	if _, ok := lookupJSONPath(body, "$.heartbeat.status"); !ok {
+		return markdownMessage("Uptime Kuma", msg, priorityInfo, ""), nil
+	}
+
+	name := jsonString(body, "$.monitor.name")
+	var (
+		state    string
+		priority int
+	)
+	switch jsonString(body, "$.heartbeat.status") {
+	case "0":
+		state, priority = "DOWN", priorityCritical
+	case "1":
+		state, priority = "UP", priorityResolved
+	case "2":
+		state, priority = "PENDING", priorityWarning
+	default:
+		state, priority = "MAINTENANCE", priorityInfo
+	}
+
+	message := jsonString(body, "$.heartbeat.msg")
+	if message == "" {
+		message = msg
+	}
+	if t := jsonString(body, "$.heartbeat.time"); t != "" {
+		message += "\n\n" + t
+	}
+
+	return markdownMessage(fmt.Sprintf("[%s] %s", state, name), message, priority, jsonString(body, "$.monitor.url")), nil
+}
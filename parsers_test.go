@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func parseTestPayload(t *testing.T, parser, header, payload string) map[string]interface{} {
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	if header != "" {
+		req.Header.Set("X-GitHub-Event", header)
+	}
+	var body interface{}
+	assert.NoError(t, json.Unmarshal([]byte(payload), &body))
+
+	msg, err := inboundParsers[parser](req, body)
+	assert.NoError(t, err)
+	return map[string]interface{}{
+		"title":    msg.Title,
+		"message":  msg.Message,
+		"priority": msg.Priority,
+		"click":    jsonString(msg.Extras, `$["client::notification"].click.url`),
+	}
+}
+
+func TestParseAlertmanager(t *testing.T) {
+	msg := parseTestPayload(t, "alertmanager", "", `{
+		"receiver": "gotify", "status": "firing", "externalURL": "http://am:9093",
+		"groupLabels": {"alertname": "DiskFull"},
+		"alerts": [
+			{"status": "firing", "labels": {"alertname": "DiskFull", "severity": "critical", "instance": "db1"}, "annotations": {"summary": "Disk is full"}},
+			{"status": "resolved", "labels": {"alertname": "DiskFull", "severity": "warning", "instance": "db2"}, "annotations": {}}
+		]}`)
+	assert.Equal(t, "[FIRING:1] DiskFull", msg["title"])
+	assert.Equal(t, "- **FIRING** Disk is full (instance=db1)\n- **RESOLVED** DiskFull (instance=db2)", msg["message"])
+	assert.Equal(t, priorityCritical, msg["priority"])
+	assert.Equal(t, "http://am:9093", msg["click"])
+
+	msg = parseTestPayload(t, "alertmanager", "", `{"status": "resolved", "groupLabels": {"alertname": "DiskFull"},
+		"alerts": [{"status": "resolved", "labels": {"severity": "critical"}, "annotations": {"summary": "ok"}}]}`)
+	assert.Equal(t, "[RESOLVED] DiskFull", msg["title"])
+	assert.Equal(t, priorityResolved, msg["priority"])
+}
+
+func TestParseGrafana(t *testing.T) {
+	msg := parseTestPayload(t, "grafana", "", `{"title": "[FIRING:1] HighCPU", "message": "CPU above 90%", "status": "firing",
+		"alerts": [{"status": "firing", "labels": {"alertname": "HighCPU"}, "annotations": {}}]}`)
+	assert.Equal(t, "[FIRING:1] HighCPU", msg["title"])
+	assert.Equal(t, "CPU above 90%", msg["message"])
+	assert.Equal(t, priorityWarning, msg["priority"])
+
+	msg = parseTestPayload(t, "grafana", "", `{"state": "alerting", "ruleName": "HighCPU", "message": "CPU high",
+		"ruleUrl": "http://grafana/d/1", "evalMatches": [{"metric": "cpu", "value": 95.5}]}`)
+	assert.Equal(t, "[ALERTING] HighCPU", msg["title"])
+	assert.Equal(t, "CPU high\n- cpu: 95.5", msg["message"])
+	assert.Equal(t, priorityCritical, msg["priority"])
+	assert.Equal(t, "http://grafana/d/1", msg["click"])
+}
+
+func TestParseGitHub(t *testing.T) {
+	msg := parseTestPayload(t, "github", "push", `{"ref": "refs/heads/main", "compare": "https://github.com/o/r/compare/a...b",
+		"repository": {"full_name": "o/r"}, "sender": {"login": "alice"},
+		"commits": [{"id": "0123456789abcdef", "url": "https://github.com/o/r/commit/0123456", "message": "Fix bug\n\nDetails"}]}`)
+	assert.Equal(t, "[o/r] alice pushed 1 commit(s) to main", msg["title"])
+	assert.Equal(t, "- [`0123456`](https://github.com/o/r/commit/0123456) Fix bug", msg["message"])
+	assert.Equal(t, "https://github.com/o/r/compare/a...b", msg["click"])
+
+	msg = parseTestPayload(t, "github", "pull_request", `{"action": "closed", "number": 42, "repository": {"full_name": "o/r"},
+		"sender": {"login": "bob"}, "pull_request": {"title": "Add feature", "body": "", "merged": true, "html_url": "https://github.com/o/r/pull/42"}}`)
+	assert.Equal(t, "[o/r] Pull request #42 merged by bob", msg["title"])
+	assert.Equal(t, "**Add feature**", msg["message"])
+
+	msg = parseTestPayload(t, "github", "workflow_run", `{"repository": {"full_name": "o/r"},
+		"workflow_run": {"name": "CI", "status": "completed", "conclusion": "failure", "head_branch": "main", "head_commit": {"message": "Fix"}}}`)
+	assert.Equal(t, "[o/r] Workflow CI failure", msg["title"])
+	assert.Equal(t, priorityCritical, msg["priority"])
+
+	_, err := parseGitHub(httptest.NewRequest(http.MethodPost, "/", nil), map[string]interface{}{})
+	assert.Error(t, err)
+}
+
+func TestParseGitLab(t *testing.T) {
+	msg := parseTestPayload(t, "gitlab", "", `{"object_kind": "pipeline", "user": {"name": "Carol"},
+		"project": {"path_with_namespace": "g/p", "web_url": "https://gitlab.com/g/p"},
+		"object_attributes": {"id": 1234567890, "status": "failed", "ref": "main"}, "commit": {"message": "Break build"}}`)
+	assert.Equal(t, "[g/p] Pipeline #1234567890 failed", msg["title"])
+	assert.Equal(t, "Ref main, commit Break build", msg["message"])
+	assert.Equal(t, priorityCritical, msg["priority"])
+	assert.Equal(t, "https://gitlab.com/g/p/-/pipelines/1234567890", msg["click"])
+
+	msg = parseTestPayload(t, "gitlab", "", `{"object_kind": "merge_request", "user": {"name": "Carol"},
+		"project": {"path_with_namespace": "g/p"},
+		"object_attributes": {"iid": 7, "action": "open", "title": "Add feature", "description": "Details", "url": "https://gitlab.com/g/p/-/merge_requests/7"}}`)
+	assert.Equal(t, "[g/p] Merge request !7 open by Carol", msg["title"])
+	assert.Equal(t, "**Add feature**\n\nDetails", msg["message"])
+	assert.Equal(t, "https://gitlab.com/g/p/-/merge_requests/7", msg["click"])
+}
+
+func TestParseUptimeKuma(t *testing.T) {
+	msg := parseTestPayload(t, "uptime-kuma", "", `{"msg": "[Website] [🔴 Down] timeout",
+		"monitor": {"name": "Website", "url": "https://example.com"},
+		"heartbeat": {"status": 0, "msg": "timeout", "time": "2024-01-01 10:00:00"}}`)
+	assert.Equal(t, "[DOWN] Website", msg["title"])
+	assert.Equal(t, "timeout\n\n2024-01-01 10:00:00", msg["message"])
+	assert.Equal(t, priorityCritical, msg["priority"])
+	assert.Equal(t, "https://example.com", msg["click"])
+
+	msg = parseTestPayload(t, "uptime-kuma", "", `{"msg": "Test notification", "monitor": null, "heartbeat": null}`)
+	assert.Equal(t, "Uptime Kuma", msg["title"])
+	assert.Equal(t, priorityInfo, msg["priority"])
+}
+
+func TestHandleInboundParser(t *testing.T) {
+	handler := &recordingMessageHandler{}
+	p := &MultiNotifierPlugin{msgHandler: handler, config: &Config{Inbound: []*InboundRoute{
+		{Name: "kuma", Parser: "uptime-kuma", Priority: "9", Auth: &InboundAuth{Insecure: true}},
+	}}}
+	for _, route := range p.config.Inbound {
+		assert.NoError(t, route.compile())
+	}
+	router := newTestRouter(p)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/plugin/1/custom/xyz/inbound/kuma",
+		strings.NewReader(`{"msg": "up", "monitor": {"name": "API"}, "heartbeat": {"status": 1, "msg": "OK"}}`)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	if assert.Len(t, handler.sent(), 1) {
+		msg := handler.sent()[0]
+		assert.Equal(t, "[UP] API", msg.Title)
+		assert.Equal(t, "OK", msg.Message)
+		assert.Equal(t, 9, msg.Priority)
+		assert.Equal(t, "text/markdown", jsonString(msg.Extras, `$["client::display"].contentType`))
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/plugin/1/custom/xyz/inbound/kuma", strings.NewReader(`{}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	assert.Error(t, (&InboundRoute{Name: "x", Parser: "unknown"}).compile())
+}
@@ -9,6 +9,7 @@ import (
 	"log/slog"
 	"net/http"
 	"net/url"
+	"path"
 	"strings"
 	"sync"
 	"text/template"
@@ -37,60 +38,155 @@ type MessageExternal struct {
 	Priority      int                    `form:"priority" query:"priority" json:"priority"`
 	Extras        map[string]interface{} `form:"-" query:"-" json:"extras,omitempty"`
 	Date          time.Time              `json:"date"`
+
+	app        *Application
+	duplicates int
+	// relay is the JSON encoding of the relay extra after forwarding the message.
+	relay string
 }
 
 // EchoPlugin is the gotify plugin instance.
 type MultiNotifierPlugin struct {
 	msgHandler     plugin.MessageHandler
 	storageHandler plugin.StorageHandler
-	config         *Config
-	cancel         context.CancelFunc
+	basePath       string
+	stats          deliveryStats
+	stream         streamStatus
+	failures       failureReporter
+	relayMu        sync.Mutex
+	relayInstance  string
+	// replays outlives reloads, inbound requests received with the previous config stay rejected.
+	replays replayGuard
+
+	// configMu guards the config and the state built from it, they are replaced together on reload.
+	configMu  sync.RWMutex
+	config    *Config
+	apps      *appCache
+	escalator *escalator
+	tracer    *tracer
+
+	// lifecycleMu serializes enabling, disabling and reloading the plugin.
+	lifecycleMu sync.Mutex
+	ctx         context.Context
+	cancel      context.CancelFunc
+	stopTracer  context.CancelFunc
+	stopStream  func()
+
+	storageMu sync.Mutex
+}
+
+// currentConfig returns the active config. Callers keep the returned config for the whole
+// delivery, so a reload doesn't change it under them.
+func (p *MultiNotifierPlugin) currentConfig() *Config {
+	p.configMu.RLock()
+	defer p.configMu.RUnlock()
+	return p.config
+}
+
+func (p *MultiNotifierPlugin) currentApps() *appCache {
+	p.configMu.RLock()
+	defer p.configMu.RUnlock()
+	return p.apps
+}
+
+func (p *MultiNotifierPlugin) currentTracer() *tracer {
+	p.configMu.RLock()
+	defer p.configMu.RUnlock()
+	return p.tracer
 }
 
 // Enable enables the plugin.
 func (p *MultiNotifierPlugin) Enable() error {
-	if len(p.config.HostServer) < 1 {
+	p.lifecycleMu.Lock()
+	defer p.lifecycleMu.Unlock()
+
+	config := p.currentConfig()
+	if config == nil || len(config.HostServer) < 1 {
 		return errors.New("please enter the correct web server")
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
-	p.cancel = cancel
+	p.ctx, p.cancel = ctx, cancel
+
+	registerSecrets(p, config.secrets)
+	p.startStats(ctx)
+	p.startTracer(ctx, p.currentTracer())
+	p.startBatches(ctx, config.WebHooks)
+	p.startDeliveries(ctx, config.WebHooks)
+	p.startEscalations(ctx, config)
+	p.startStream(ctx, config)
 
-	serverUrl := p.config.HostServer + "/stream"
+	logger.Info("Webhook plugin enabled", slog.Any("config", GetGotifyPluginInfo()))
+
+	return nil
+}
 
+// startTracer exports the spans of the tracer until ctx is done or the tracer is replaced.
+func (p *MultiNotifierPlugin) startTracer(ctx context.Context, tr *tracer) {
+	ctx, cancel := context.WithCancel(ctx)
+	p.stopTracer = cancel
+	tr.run(ctx)
+}
+
+// startStream forwards the messages of the Gotify stream until ctx is done or the stream is
+// stopped. Deliveries run with ctx, so stopping the stream doesn't abort them.
+func (p *MultiNotifierPlugin) startStream(ctx context.Context, config *Config) {
+	streamCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	p.stopStream = func() {
+		cancel()
+		<-done
+	}
+
+	serverUrl := config.HostServer + "/stream"
 	go func() {
+		defer close(done)
 		for {
 			select {
-			case <-ctx.Done():
-				slog.Info("Plugin stopped")
+			case <-streamCtx.Done():
+				logger.Info("Plugin stopped")
 				return
 			default:
-				err := p.receiveMessages(ctx, serverUrl)
+				err := p.receiveMessages(streamCtx, ctx, serverUrl, config.ClientToken)
 				if err != nil {
 					if errors.Is(err, context.Canceled) {
-						slog.Info("ReceiveMessages canceled")
+						logger.Info("ReceiveMessages canceled")
 						return
 					}
-					slog.Error("Read message error, retrying after 1s", slog.Any("err", err))
-					time.Sleep(time.Second)
+					logger.Error("Read message error, retrying after 1s", slog.Any("err", err))
+					select {
+					case <-streamCtx.Done():
+					case <-time.After(time.Second):
+					}
 				} else {
 					return
 				}
 			}
 		}
 	}()
-
-	slog.Info("Webhook plugin enabled", slog.Any("config", GetGotifyPluginInfo()))
-
-	return nil
 }
 
 // Disable disables the plugin.
 func (p *MultiNotifierPlugin) Disable() error {
+	p.lifecycleMu.Lock()
+	defer p.lifecycleMu.Unlock()
+
 	if p.cancel != nil {
 		p.cancel()
+		p.ctx, p.cancel = nil, nil
 	}
-	slog.Info("Webhook plugin disbled", slog.Any("config", GetGotifyPluginInfo()))
+	if config := p.currentConfig(); config != nil {
+		for _, webhook := range config.WebHooks {
+			webhook.Dedup.stop()
+		}
+	}
+	// Stopping the escalations right away persists their alerts before Disable returns.
+	p.configMu.RLock()
+	escalator := p.escalator
+	p.configMu.RUnlock()
+	escalator.stop()
+	logger.Info("Webhook plugin disbled", slog.Any("config", GetGotifyPluginInfo()))
+	registerSecrets(p, nil)
 	return nil
 }
 
@@ -106,22 +202,94 @@ func (p *MultiNotifierPlugin) SetMessageHandler(h plugin.MessageHandler) {
 
 // Storage defines the plugin storage scheme
 type Storage struct {
-	CalledTimes int `json:"called_times"`
+	CalledTimes int                         `json:"called_times"`
+	Batches     map[string][]*BatchEntry    `json:"batches,omitempty"`
+	Escalations map[string]*EscalationState `json:"escalations,omitempty"`
+	Stats       map[string]*WebhookStats    `json:"stats,omitempty"`
+	History     []*DeliveryRecord           `json:"history,omitempty"`
 }
 
 type WebHook struct {
-	Url    string            `yaml:"url"`
-	Method string            `yaml:"method"`
-	Body   string            `yaml:"body"`
-	Header map[string]string `yaml:"header"`
-	Apps   []uint            `yaml:"apps"`
+	Name       string            `yaml:"name"`
+	Url        string            `yaml:"url"`
+	Method     string            `yaml:"method"`
+	Body       string            `yaml:"body"`
+	BodyFormat string            `yaml:"body_format"`
+	BodyRoot   string            `yaml:"body_root"`
+	BodyFields []*BodyField      `yaml:"body_fields"`
+	Header     map[string]string `yaml:"header"`
+	Apps       AppList           `yaml:"apps"`
+	Filter     *Filter           `yaml:"filter"`
+	When       string            `yaml:"when"`
+	Schedule   *Schedule         `yaml:"schedule"`
+	Dedup      *Dedup            `yaml:"dedup"`
+	Batch      *Batch            `yaml:"batch"`
+	Signing    *Signing          `yaml:"signing"`
+	Auth       *Auth             `yaml:"auth"`
+	Retry      *Retry            `yaml:"retry"`
+	// EscalationOnly webhooks only receive messages escalated to them.
+	EscalationOnly bool `yaml:"escalation_only"`
+
+	when  *Expression
+	queue *deliveryQueue
+	// key identifies the persisted state of the webhook, see webhookKey.
+	key string
 }
 
 // Config defines the plugin config scheme
 type Config struct {
-	ClientToken string     `yaml:"client_token" validate:"required"`
-	HostServer  string     `yaml:"host_server" validate:"required"`
-	WebHooks    []*WebHook `yaml:"web_hooks"`
+	ClientToken        string          `yaml:"client_token" validate:"required"`
+	HostServer         string          `yaml:"host_server" validate:"required"`
+	AppRefreshInterval Duration        `yaml:"app_refresh_interval"`
+	WebHooks           []*WebHook      `yaml:"web_hooks"`
+	Escalations        []*Escalation   `yaml:"escalations"`
+	Inbound            []*InboundRoute `yaml:"inbound"`
+	// MetricsToken protects the metrics route, it is expected as `Authorization: Bearer <token>`.
+	MetricsToken string `yaml:"metrics_token"`
+	// APIToken protects the history route, it is expected as `Authorization: Bearer <token>`.
+	APIToken string `yaml:"api_token"`
+	// HistorySize is the number of deliveries kept in the history.
+	HistorySize int `yaml:"history_size"`
+	// FailureNotifications reports failing webhooks as Gotify messages.
+	FailureNotifications *FailureNotifications `yaml:"failure_notifications"`
+	// MaxHops limits how often a message is relayed between Gotify servers.
+	MaxHops int `yaml:"max_hops"`
+	// Tracing exports spans to an OpenTelemetry collector, without it tracing is disabled.
+	Tracing *Tracing `yaml:"tracing"`
+	// DryRun renders and logs the webhook requests instead of sending them.
+	DryRun bool `yaml:"dry_run"`
+
+	// secrets are the values of the secret references, masked in logs while the plugin is enabled.
+	secrets []string
+}
+
+// Duration is a time.Duration written as a string like "1h30m" in the config.
+type Duration time.Duration
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	if s == "" {
+		*d = 0
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalYAML implements yaml.Marshaler.
+func (d Duration) MarshalYAML() (interface{}, error) {
+	if d == 0 {
+		return "", nil
+	}
+	return time.Duration(d).String(), nil
 }
 
 // DefaultConfig implements plugin.Configurer
@@ -135,49 +303,207 @@ func (p *MultiNotifierPlugin) DefaultConfig() interface{} {
 
 // ValidateAndSetConfig implements plugin.Configurer
 func (p *MultiNotifierPlugin) ValidateAndSetConfig(config interface{}) error {
-	p.config = config.(*Config)
+	if destinationPolicyErr != nil {
+		return destinationPolicyErr
+	}
+	resolved, secrets, err := resolveSecrets(config.(*Config))
+	if err != nil {
+		return err
+	}
+	resolved.secrets = secrets
+	if resolved.HistorySize < 0 {
+		return fmt.Errorf("history_size must not be negative")
+	}
+	if resolved.MaxHops < 0 {
+		return fmt.Errorf("max_hops must not be negative")
+	}
+	if err := resolved.FailureNotifications.compile(); err != nil {
+		return fmt.Errorf("invalid failure_notifications: %w", err)
+	}
+	if err := resolved.Tracing.compile(); err != nil {
+		return fmt.Errorf("invalid tracing: %w", err)
+	}
+
 	validWebhooks := make([]*WebHook, 0)
+	names := make(map[string]bool)
+	urlKeys := make(map[string]int)
+
+	for i, webhook := range resolved.WebHooks {
+		if webhook.Name != "" {
+			if names[webhook.Name] {
+				return fmt.Errorf("duplicate webhook name: %s", webhook.Name)
+			}
+			names[webhook.Name] = true
+			webhook.key = webhook.Name
+		} else {
+			// Unnamed webhooks are told apart by their URL before resolving secrets and, if it
+			// repeats, by its occurrence.
+			webhook.key = urlKey(config.(*Config).WebHooks[i].Url)
+			urlKeys[webhook.key]++
+			if n := urlKeys[webhook.key]; n > 1 {
+				webhook.key = fmt.Sprintf("%s-%d", webhook.key, n)
+			}
+		}
 
-	for _, webhook := range p.config.WebHooks {
 		if webhook.Method == "" {
 			webhook.Method = "POST"
 		}
 
+		for _, pattern := range webhook.Apps.Names {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid webhook app name pattern %q for %s: %w", pattern, webhook.Url, err)
+			}
+		}
+
 		parsedURL, err := url.Parse(webhook.Url)
 		if err != nil || parsedURL.Scheme == "" || parsedURL.Host == "" {
 			return fmt.Errorf("invalid webhook URL: %s", webhook.Url)
 		}
+		if err := destinationPolicy.checkURL(parsedURL); err != nil {
+			return fmt.Errorf("invalid webhook URL: %w", err)
+		}
+
+		if err := webhook.Filter.compile(); err != nil {
+			return fmt.Errorf("invalid webhook filter for %s: %w", webhook.Url, err)
+		}
+
+		webhook.when = nil
+		if webhook.When != "" {
+			expr, err := CompileExpression(webhook.When)
+			if err != nil {
+				return fmt.Errorf("invalid webhook when expression for %s: %w", webhook.Url, err)
+			}
+			webhook.when = expr
+		}
+
+		if err := webhook.Schedule.compile(); err != nil {
+			return fmt.Errorf("invalid webhook schedule for %s: %w", webhook.Url, err)
+		}
+
+		if err := webhook.Dedup.compile(); err != nil {
+			return fmt.Errorf("invalid webhook dedup for %s: %w", webhook.Url, err)
+		}
+
+		if err := validateBodyFormat(webhook); err != nil {
+			return fmt.Errorf("invalid webhook body for %s: %w", webhook.Url, err)
+		}
+
+		if err := webhook.Batch.compile(webhook); err != nil {
+			return fmt.Errorf("invalid webhook batch for %s: %w", webhook.Url, err)
+		}
+
+		if err := webhook.Signing.compile(); err != nil {
+			return fmt.Errorf("invalid webhook signing for %s: %w", webhook.Url, err)
+		}
+
+		if err := webhook.Auth.compile(); err != nil {
+			return fmt.Errorf("invalid webhook auth for %s: %w", webhook.Url, err)
+		}
+
+		if err := webhook.Retry.compile(); err != nil {
+			return fmt.Errorf("invalid webhook retry for %s: %w", webhook.Url, err)
+		}
 
 		if _, exists := webhook.Header["Content-Type"]; !exists {
-			if webhook.Header == nil {
-				webhook.Header = make(map[string]string)
+			if contentType := defaultContentType(webhook.BodyFormat); contentType != "" {
+				if webhook.Header == nil {
+					webhook.Header = make(map[string]string)
+				}
+				webhook.Header["Content-Type"] = contentType
 			}
-			webhook.Header["Content-Type"] = "text/plain"
 		}
 
+		webhook.queue = newDeliveryQueue()
 		validWebhooks = append(validWebhooks, webhook)
 	}
 
-	p.config.WebHooks = validWebhooks
+	resolved.WebHooks = validWebhooks
+
+	for _, escalation := range resolved.Escalations {
+		if err := escalation.compile(resolved.WebHooks); err != nil {
+			return fmt.Errorf("invalid escalation %s: %w", escalation.Name, err)
+		}
+	}
+
+	inboundNames := make(map[string]bool)
+	for _, route := range resolved.Inbound {
+		if err := route.compile(); err != nil {
+			return fmt.Errorf("invalid inbound route %s: %w", route.Name, err)
+		}
+		if inboundNames[route.Name] {
+			return fmt.Errorf("duplicate inbound route name: %s", route.Name)
+		}
+		inboundNames[route.Name] = true
+		if route.Auth.open() {
+			logger.Warn("Inbound route accepts unauthenticated requests", slog.String("route", route.Name))
+		}
+	}
 
+	var tr *tracer
+	if resolved.Tracing != nil {
+		tr = newTracer(&otlpExporter{config: resolved.Tracing})
+	}
+	apps := newAppCache(resolved.HostServer, resolved.ClientToken, time.Duration(resolved.AppRefreshInterval))
+
+	p.lifecycleMu.Lock()
+	defer p.lifecycleMu.Unlock()
+	if p.ctx != nil {
+		registerSecrets(p, secrets)
+	}
+	p.stats.setHistorySize(resolved.HistorySize)
+	p.applyConfig(resolved, apps, tr)
 	return nil
 }
 
-// GetDisplay implements plugin.Displayer.
-func (p *MultiNotifierPlugin) GetDisplay(location *url.URL) string {
-	message := `
+// applyConfig replaces the active config. If the plugin is enabled, the background work is moved
+// to the new config while deliveries in flight finish with the webhooks of the old one. The
+// stream is only reconnected if the server or the client token changed. The caller must hold
+// p.lifecycleMu.
+func (p *MultiNotifierPlugin) applyConfig(config *Config, apps *appCache, tr *tracer) {
+	p.configMu.Lock()
+	old := p.config
+	p.config, p.apps, p.tracer = config, apps, tr
+	if p.ctx == nil || old == nil {
+		p.configMu.Unlock()
+		return
+	}
+	// Handing the batches over while holding the lock makes messages forwarded to the closed
+	// batches of the old config find the new ones.
+	orphans := handOverBatches(old.WebHooks, config.WebHooks)
+	p.configMu.Unlock()
+
+	p.stopTracer()
+	p.startTracer(p.ctx, tr)
+	p.reloadBatches(p.ctx, config.WebHooks, orphans)
+	// The old queues deliver the messages waiting in them before their workers exit.
+	for _, webhook := range old.WebHooks {
+		webhook.queue.close()
+	}
+	p.startDeliveries(p.ctx, config.WebHooks)
+	p.handOverWebHooks(old.WebHooks)
+	p.startEscalations(p.ctx, config)
+	if config.HostServer != old.HostServer || config.ClientToken != old.ClientToken {
+		p.stopStream()
+		p.startStream(p.ctx, config)
+	}
+	logger.Info("Config reloaded", slog.Int("webhooks", len(config.WebHooks)))
+}
+
+// setupGuide is shown on the plugin page until the config is complete.
+const setupGuide = `
 	Guide:
 
 	1. Create a new client and put its token into the client_token option.
 	2. Update the host_server option if it is different with the default 'ws://localhost'.
-	3. Configurate webhooks.
+	3. Configurate webhooks. JSON bodies posted to the /message?token=... API of a Gotify server
+	   get the webhook::relay extra which prevents loops, other bodies should set it with {{.relay}}.
 
 	Webhook example:
 
 	web_hooks: 
 	  - url: http://example.com/api/messages
 	    body: "{{.title}}\n\n{{.message}}"
-	  - url: http://192.168.1.2:10201/api/sendTextMsg	
+	  - url: https://chat.example.com/api/sendTextMsg
 	    apps:
 	      - 1
 	    method: POST
@@ -185,21 +511,43 @@ func (p *MultiNotifierPlugin) GetDisplay(location *url.URL) string {
 	      Content-Type: application/json
 	    body: "{\"wxid\":\"xxxxxxxx\",\"msg\":\"{{.title}}\n{{.message}}\"}"
 
-	Note: Re-enable the plugin after making changes.
+	Changes apply immediately, the plugin doesn't need to be re-enabled.
+
+	Webhooks in private networks, e.g. http://192.168.1.2, are blocked unless the server admin
+	allows them with the GOTIFY_WEBHOOK_ALLOWED_DESTINATIONS environment variable.
 	`
-	return message
+
+// GetDisplay implements plugin.Displayer.
+func (p *MultiNotifierPlugin) GetDisplay(location *url.URL) string {
+	config := p.currentConfig()
+	if config == nil {
+		return setupGuide
+	}
+	status := p.statusMarkdown()
+	if config.ClientToken == "" || len(config.WebHooks) == 0 {
+		return setupGuide + "\n\n" + status
+	}
+	return status
 }
 
-func (p *MultiNotifierPlugin) receiveMessages(ctx context.Context, serverUrl string) (err error) {
+// receiveMessages forwards the messages of the stream until ctx is done, the messages are
+// delivered with deliveryCtx.
+func (p *MultiNotifierPlugin) receiveMessages(ctx, deliveryCtx context.Context, serverUrl, token string) (err error) {
 	header := http.Header{}
-	header.Add("Authorization", "Bearer "+p.config.ClientToken)
-	conn, _, err := websocket.DefaultDialer.Dial(serverUrl, header)
+	header.Add("Authorization", "Bearer "+token)
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, serverUrl, header)
 	if err != nil {
+		p.stream.setDisconnected(err)
 		return fmt.Errorf("dial error: %w", err)
 	}
 	defer conn.Close()
 
-	slog.Info("Connected to Websocket server", slog.String("url", serverUrl))
+	p.stream.setConnected()
+	defer func() {
+		p.stream.setDisconnected(err)
+	}()
+
+	logger.Info("Connected to Websocket server", slog.String("url", serverUrl))
 
 	readErrCh := make(chan error, 1)
 
@@ -219,14 +567,14 @@ func (p *MultiNotifierPlugin) receiveMessages(ctx context.Context, serverUrl str
 
 				msg := &MessageExternal{}
 				if err := json.Unmarshal(message, msg); err != nil {
-					slog.Warn("Unsupported message format", slog.Any("message", string(message)))
+					logger.Warn("Unsupported message format", slog.Any("message", string(message)))
 					continue
 				}
 
-				errs := p.sendMessage(ctx, msg, p.config.WebHooks)
+				errs := p.sendMessage(deliveryCtx, msg, p.currentConfig().WebHooks)
 				if len(errs) > 0 {
 					for _, err := range errs {
-						slog.Error("Failed to send message", slog.Any("error", err))
+						logger.Error("Failed to send message", slog.Any("error", err))
 					}
 				}
 			}
@@ -239,7 +587,7 @@ func (p *MultiNotifierPlugin) receiveMessages(ctx context.Context, serverUrl str
 	for {
 		select {
 		case <-ctx.Done():
-			slog.Info("Context cancelled, closing WebSocket connection")
+			logger.Info("Context cancelled, closing WebSocket connection")
 			err := conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
 			if err != nil {
 				return fmt.Errorf("write close message error: %w", err)
@@ -263,51 +611,54 @@ func (p *MultiNotifierPlugin) sendMessage(ctx context.Context, msg *MessageExter
 		wg sync.WaitGroup
 	)
 
+	// The plugin's own notifications could fail the same webhooks again.
+	if isNotification(msg) {
+		return nil
+	}
+	relayID, err := p.relayID()
+	if err != nil {
+		logger.Error("Dropping message", slog.Uint64("id", uint64(msg.ID)), slog.Any("err", err))
+		return []error{err}
+	}
+	if reason := p.loopReason(relayID, messageRelay(msg)); reason != "" {
+		logger.Warn("Dropping relayed message", slog.Uint64("id", uint64(msg.ID)), slog.String("reason", reason))
+		return nil
+	}
+	msg.relay = nextRelay(relayID, []*MessageExternal{msg}).String()
+
+	if apps := p.currentApps(); apps != nil && msg.app == nil {
+		app, err := apps.get(ctx, msg.ApplicationID)
+		if err != nil {
+			logger.Warn("Failed to resolve application", slog.Uint64("appid", uint64(msg.ApplicationID)), slog.Any("err", err))
+		}
+		msg.app = app
+	}
+
+	ctx, span := p.currentTracer().start(ctx, "receive message", spanKindConsumer)
+	span.setAttr("gotify.message.id", int(msg.ID))
+	span.setAttr("gotify.app.id", int(msg.ApplicationID))
+	defer func() {
+		if len(errors) > 0 {
+			span.finish(errors[0])
+		} else {
+			span.finish(nil)
+		}
+	}()
+
+	p.stats.countMessage()
+
+	errors = append(errors, p.processEscalations(msg)...)
+
 	for _, webhook := range webhooks {
 		webhook := webhook // Create local variable for closure, for golang 1.22 and older versions.
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
 
-			if ctx.Err() != nil {
-				mu.Lock()
-				errors = append(errors, ctx.Err())
-				mu.Unlock()
-				return
-			}
-
-			// Only messages from white-listed applications can be forwarded.
-			if len(webhook.Apps) > 0 {
-				appAllowed := false
-				for _, appID := range webhook.Apps {
-					if appID == msg.ApplicationID {
-						appAllowed = true
-						break
-					}
-				}
-				if !appAllowed {
-					return
-				}
-			}
-
-			// Process the webhook body
-			body, err := p.processWebhookBody(webhook.Body, msg)
-			if err != nil {
-				err = fmt.Errorf("failed to process webhook body for %s: %w", webhook.Url, err)
-				mu.Lock()
-				errors = append(errors, err)
-				mu.Unlock()
-				return
-			}
-
-			// Send the HTTP request
-			err = p.sendHTTPRequest(ctx, webhook, body)
-			if err != nil {
-				err = fmt.Errorf("failed to send webhook request to %s: %w", webhook.Url, err)
+			if err := p.forwardMessage(ctx, webhook, msg); err != nil {
 				mu.Lock()
 				errors = append(errors, err)
 				mu.Unlock()
-				return
 			}
 		}()
 	}
@@ -317,13 +668,122 @@ func (p *MultiNotifierPlugin) sendMessage(ctx context.Context, msg *MessageExter
 	return errors
 }
 
+// Outcomes of filtering a message for a webhook.
+const (
+	filterForwarded = "forwarded"
+	filterFiltered  = "filtered"
+	filterThrottled = "throttled"
+	filterDeferred  = "deferred"
+)
+
+// forwardMessage delivers the message to the webhook if it passes the webhook's filters and
+// schedule.
+func (p *MultiNotifierPlugin) forwardMessage(ctx context.Context, webhook *WebHook, msg *MessageExternal) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if webhook.EscalationOnly {
+		return nil
+	}
+
+	_, span := p.currentTracer().start(ctx, "filter message", spanKindInternal)
+	span.setAttr("webhook", webhookLabel(webhook))
+	outcome, err := p.filterMessage(ctx, webhook, msg)
+	span.setAttr("webhook.filter.outcome", outcome)
+	span.finish(err)
+	if err != nil || outcome != filterForwarded {
+		return err
+	}
+
+	if webhook.Batch != nil {
+		return p.addToBatch(ctx, webhook, msg)
+	}
+
+	return p.queueDelivery(ctx, webhook, msg)
+}
+
+// filterMessage decides whether the message is forwarded to the webhook now.
+func (p *MultiNotifierPlugin) filterMessage(ctx context.Context, webhook *WebHook, msg *MessageExternal) (string, error) {
+	// Only messages from white-listed applications can be forwarded.
+	if !webhook.appAllowed(msg) {
+		p.stats.update(webhook, func(stats *WebhookStats) { stats.Filtered++ })
+		return filterFiltered, nil
+	}
+
+	// Filter rules are evaluated before rendering, so dropped messages cost nothing.
+	if !webhook.Filter.Match(msg) {
+		p.stats.update(webhook, func(stats *WebhookStats) { stats.Filtered++ })
+		return filterFiltered, nil
+	}
+	if webhook.when != nil {
+		matched, err := webhook.when.Match(msg)
+		if err != nil {
+			return "", fmt.Errorf("failed to evaluate when expression for %s: %w", webhook.Url, err)
+		}
+		if !matched {
+			p.stats.update(webhook, func(stats *WebhookStats) { stats.Filtered++ })
+			return filterFiltered, nil
+		}
+	}
+
+	// Outside of the schedule only messages with a priority above the threshold get through.
+	if schedule := webhook.Schedule; schedule != nil && !schedule.Active(time.Now()) {
+		if schedule.BypassPriority == nil || msg.Priority < *schedule.BypassPriority {
+			if schedule.OutOfWindow == OutOfWindowDefer {
+				return filterDeferred, p.deferMessage(ctx, webhook, msg)
+			}
+			p.stats.update(webhook, func(stats *WebhookStats) { stats.Throttled++ })
+			return filterThrottled, nil
+		}
+	}
+
+	suppressed, err := p.deduplicate(ctx, webhook, msg)
+	if suppressed {
+		p.stats.update(webhook, func(stats *WebhookStats) { stats.Throttled++ })
+		return filterThrottled, err
+	}
+	if err != nil {
+		return "", err
+	}
+	return filterForwarded, nil
+}
+
+// deliverMessage renders the message and sends it to the webhook.
+func (p *MultiNotifierPlugin) deliverMessage(ctx context.Context, webhook *WebHook, msg *MessageExternal) error {
+	// Process the webhook body
+	_, span := p.currentTracer().start(ctx, "render message", spanKindInternal)
+	span.setAttr("webhook", webhookLabel(webhook))
+	body, contentType, err := p.renderWebhookBody(ctx, webhook, msg)
+	span.finish(err)
+	if err != nil {
+		p.stats.failed(webhook, err)
+		p.reportDelivery(webhook, err)
+		return fmt.Errorf("failed to process webhook body for %s: %w", webhook.Url, err)
+	}
+
+	// Send the HTTP request
+	err = p.sendHTTPRequest(ctx, webhook, body, contentType, msg)
+	if err != nil {
+		return fmt.Errorf("failed to send webhook request to %s: %w", webhook.Url, err)
+	}
+
+	return nil
+}
+
 func (p *MultiNotifierPlugin) processWebhookBody(body string, msg *MessageExternal) (string, error) {
+	return processBodyTemplate(body, templateData(msg))
+}
+
+// processBodyTemplate processes the body as JSON structured template if it is valid JSON,
+// otherwise as plain text template.
+func processBodyTemplate(body string, data map[string]interface{}) (string, error) {
 	var jsonBody map[string]interface{}
 	isJSON := json.Unmarshal([]byte(body), &jsonBody) == nil
 
 	if isJSON {
 		// Process JSON structured template
-		err := processJSONRecursive(jsonBody, msg)
+		err := processJSONTemplate(jsonBody, data)
 		if err != nil {
 			return "", fmt.Errorf("failed to process JSON body: %w", err)
 		}
@@ -335,69 +795,189 @@ func (p *MultiNotifierPlugin) processWebhookBody(body string, msg *MessageExtern
 		return string(newBody), nil
 	} else {
 		// Process plain text template
-		return processTemplateString(body, msg)
+		return executeTemplate(body, data)
 	}
 }
 
-func (p *MultiNotifierPlugin) sendHTTPRequest(ctx context.Context, webhook *WebHook, body string) error {
+// sendHTTPRequest sends the body with the messages to the webhook, retrying failed requests if
+// configured.
+func (p *MultiNotifierPlugin) sendHTTPRequest(ctx context.Context, webhook *WebHook, body string, contentType string, msgs ...*MessageExternal) error {
+	relayID, err := p.relayID()
+	if err != nil {
+		p.stats.failed(webhook, err)
+		return err
+	}
+	relay := nextRelay(relayID, msgs)
+	body = withRelayExtra(webhook, body, relay)
+	if config := p.currentConfig(); config != nil && config.DryRun {
+		return p.logDryRun(ctx, webhook, body, contentType, relay)
+	}
+
+	record := newDeliveryRecord(msgs)
+	id, err := newWebhookID()
+	if err != nil {
+		p.stats.failed(webhook, err)
+		return err
+	}
+	record.ID = id
+	p.stats.update(webhook, func(stats *WebhookStats) { stats.Sent++ })
+
+	err = webhook.Retry.do(ctx, func() error {
+		record.Attempts++
+		return p.doHTTPRequest(ctx, webhook, body, contentType, relay, record)
+	}, func() {
+		p.stats.update(webhook, func(stats *WebhookStats) { stats.Retried++ })
+	})
+	p.stats.delivered(webhook, record, err)
+	p.reportDelivery(webhook, err)
+	return err
+}
+
+// doHTTPRequest sends the body to the webhook once and records the response.
+func (p *MultiNotifierPlugin) doHTTPRequest(ctx context.Context, webhook *WebHook, body string, contentType string, relay *relayInfo, record *DeliveryRecord) (err error) {
+	record.StatusCode, record.Response = 0, ""
+	ctx, span := p.currentTracer().start(ctx, webhook.Method, spanKindClient)
+	span.setAttr("webhook", webhookLabel(webhook))
+	span.setAttr("http.request.method", webhook.Method)
+	span.setAttr("url.full", displayURL(webhook.Url))
+	if record.Attempts > 1 {
+		span.setAttr("http.request.resend_count", record.Attempts-1)
+	}
+	defer func() {
+		if record.StatusCode != 0 {
+			span.setAttr("http.response.status_code", record.StatusCode)
+		}
+		span.finish(err)
+	}()
+
+	req, err := newWebhookRequest(ctx, webhook, record.ID, body, contentType, relay)
+	if err != nil {
+		return err
+	}
+	span.inject(req.Header)
+	return sendWebhookRequest(webhook, req, record)
+}
+
+// newWebhookRequest creates the signed request of the delivery with the ID, it isn't authenticated
+// yet.
+func newWebhookRequest(ctx context.Context, webhook *WebHook, id string, body string, contentType string, relay *relayInfo) (*http.Request, error) {
 	req, err := http.NewRequestWithContext(ctx, webhook.Method, webhook.Url, strings.NewReader(body))
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	for k, v := range webhook.Header {
 		req.Header.Add(k, v)
 	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	relay.setHeaders(req.Header)
+	if err := webhook.Signing.sign(req, id, body, time.Now()); err != nil {
+		return nil, fmt.Errorf("failed to sign request: %w", err)
+	}
+	return req, nil
+}
 
-	res, err := http.DefaultClient.Do(req)
+// sendWebhookRequest authenticates and sends the request and records the response.
+func sendWebhookRequest(webhook *WebHook, req *http.Request, record *DeliveryRecord) error {
+	if err := webhook.Auth.apply(req.Context(), req); err != nil {
+		return fmt.Errorf("failed to authenticate request: %w", err)
+	}
+
+	res, err := webhookClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to send request: %w", err)
 	}
 	defer res.Body.Close()
+	record.StatusCode, record.Response = res.StatusCode, responseExcerpt(res.Body)
 
+	if res.StatusCode == http.StatusUnauthorized {
+		// The token may have been revoked before its expiry.
+		webhook.Auth.invalidate()
+	}
 	if res.StatusCode < 200 || res.StatusCode >= 300 {
-		return fmt.Errorf("unexpected status code: %d", res.StatusCode)
+		return &statusError{code: res.StatusCode, retryAfter: parseRetryAfter(res.Header.Get("Retry-After"), time.Now())}
 	}
 
 	return nil
 }
 
-func processJSONRecursive(m map[string]interface{}, msg *MessageExternal) (err error) {
+func processJSONRecursive(m map[string]interface{}, msg *MessageExternal) error {
+	return processJSONTemplate(m, templateData(msg))
+}
+
+func processJSONTemplate(m map[string]interface{}, data map[string]interface{}) (err error) {
 	for k, v := range m {
 		switch vv := v.(type) {
 		case string:
-			m[k], err = processTemplateString(vv, msg)
+			m[k], err = executeTemplate(vv, data)
 		case map[string]interface{}:
-			err = processJSONRecursive(vv, msg)
+			err = processJSONTemplate(vv, data)
 		case []interface{}:
 			for i, item := range vv {
 				if itemString, ok := item.(string); ok {
-					vv[i], err = processTemplateString(itemString, msg)
+					vv[i], err = executeTemplate(itemString, data)
 				} else if itemMap, ok := item.(map[string]interface{}); ok {
-					err = processJSONRecursive(itemMap, msg)
+					err = processJSONTemplate(itemMap, data)
+				}
+				if err != nil {
+					return fieldError(fmt.Sprintf("%s[%d]", k, i), err)
 				}
 			}
 		}
 
 		if err != nil {
-			return err
+			return fieldError(k, err)
 		}
 	}
 
 	return nil
 }
 
+// templateFieldError is an error of the template in a field of a JSON body.
+type templateFieldError struct {
+	// field is the path of the field, e.g. `attachments[0].text`.
+	field string
+	err   error
+}
+
+func (e *templateFieldError) Error() string {
+	return fmt.Sprintf("field %s: %v", e.field, e.err)
+}
+
+func (e *templateFieldError) Unwrap() error {
+	return e.err
+}
+
+// fieldError adds the key to the path of the field the error occurred in.
+func fieldError(key string, err error) error {
+	inner, ok := err.(*templateFieldError)
+	if !ok {
+		return &templateFieldError{field: key, err: err}
+	}
+	switch {
+	case key == "":
+		return inner
+	case strings.HasPrefix(inner.field, "["):
+		return &templateFieldError{field: key + inner.field, err: inner.err}
+	default:
+		return &templateFieldError{field: key + "." + inner.field, err: inner.err}
+	}
+}
+
 func processTemplateString(s string, msg *MessageExternal) (string, error) {
+	return executeTemplate(s, templateData(msg))
+}
+
+func executeTemplate(s string, data interface{}) (string, error) {
 	tmpl, err := template.New("").Parse(s)
 	if err != nil {
 		return "", fmt.Errorf("failed to parse template: %w", err)
 	}
 
 	var buf bytes.Buffer
-	err = tmpl.Execute(&buf, map[string]interface{}{
-		"title":   msg.Title,
-		"message": msg.Message,
-	})
+	err = tmpl.Execute(&buf, data)
 	if err != nil {
 		return "", fmt.Errorf("failed to execute template: %w", err)
 	}
@@ -405,6 +985,44 @@ func processTemplateString(s string, msg *MessageExternal) (string, error) {
 	return buf.String(), nil
 }
 
+// templateData returns the placeholders available to body templates.
+func templateData(msg *MessageExternal) map[string]interface{} {
+	extras := msg.Extras
+	if extras == nil {
+		extras = map[string]interface{}{}
+	}
+	data := map[string]interface{}{
+		"id":              msg.ID,
+		"appid":           msg.ApplicationID,
+		"title":           msg.Title,
+		"message":         msg.Message,
+		"priority":        msg.Priority,
+		"extras":          extras,
+		"image":           notificationImage(msg),
+		"app":             "",
+		"app_description": "",
+		"app_image":       "",
+		"duplicates":      msg.duplicates,
+		"relay":           msg.relay,
+	}
+	if msg.app != nil {
+		data["app"] = msg.app.Name
+		data["app_description"] = msg.app.Description
+		data["app_image"] = msg.app.ImageURL
+	}
+	return data
+}
+
+// notificationImage returns the big image URL set via the client::notification extra.
+func notificationImage(msg *MessageExternal) string {
+	notification, ok := msg.Extras["client::notification"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	image, _ := notification["bigImageUrl"].(string)
+	return image
+}
+
 // NewGotifyPluginInstance creates a plugin instance for a user context.
 func NewGotifyPluginInstance(ctx plugin.UserContext) plugin.Plugin {
 	return &MultiNotifierPlugin{}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -211,7 +212,7 @@ func TestReceiveMessages(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 	defer cancel()
 
-	err := plugin.receiveMessages(ctx, wsURL)
+	err := plugin.receiveMessages(ctx, ctx, wsURL, plugin.config.ClientToken)
 
 	assert.Error(t, err, "Expected an error from receiveMessages")
 	assert.Equal(t, "read message error: websocket: close 1000 (normal)", err.Error(),
@@ -234,7 +235,7 @@ func TestMultiNotifierPlugin_SendMessage(t *testing.T) {
 					Url:    "http://example.com",
 					Method: "POST",
 					Body:   "{\"message\": \"{{.message}}\"}",
-					Apps:   []uint{1, 2},
+					Apps:   AppList{IDs: []uint{1, 2}},
 				},
 			},
 		},
@@ -312,6 +313,109 @@ func TestMultiNotifierPlugin_Disable(t *testing.T) {
 	assert.NoError(t, err, "Calling Disable multiple times should not produce an error")
 }
 
+func TestReloadConfig(t *testing.T) {
+	// The Gotify server records the token of each stream connection.
+	tokens := make(chan string, 4)
+	conns := make(chan *websocket.Conn, 4)
+	gotify := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/stream" {
+			w.Write([]byte("[]"))
+			return
+		}
+		c, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		tokens <- strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		conns <- c
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer gotify.Close()
+	hostServer := "ws" + strings.TrimPrefix(gotify.URL, "http")
+
+	// The old webhook blocks until released, the new one records the titles.
+	release := make(chan struct{})
+	oldReceived := make(chan string, 1)
+	oldWebhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		body, _ := io.ReadAll(r.Body)
+		oldReceived <- string(body)
+	}))
+	defer oldWebhook.Close()
+	newReceived := make(chan string, 1)
+	newWebhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		newReceived <- string(body)
+	}))
+	defer newWebhook.Close()
+
+	newConfig := func(token, url string) *Config {
+		return &Config{
+			ClientToken: token,
+			HostServer:  hostServer,
+			WebHooks:    []*WebHook{{Name: "chat", Url: url, Body: "{{.title}}"}},
+		}
+	}
+	send := func(c *websocket.Conn, title string) {
+		msg, _ := json.Marshal(&MessageExternal{ID: 1, ApplicationID: 1, Title: title})
+		assert.NoError(t, c.WriteMessage(websocket.TextMessage, msg))
+	}
+	receive := func(ch chan string) string {
+		select {
+		case body := <-ch:
+			return body
+		case <-time.After(2 * time.Second):
+			t.Fatal("webhook request not received")
+			return ""
+		}
+	}
+
+	plugin := &MultiNotifierPlugin{}
+	assert.NoError(t, plugin.ValidateAndSetConfig(newConfig("first", oldWebhook.URL)))
+	assert.NoError(t, plugin.Enable())
+	defer plugin.Disable()
+	assert.Equal(t, "first", <-tokens)
+	conn := <-conns
+
+	// The delivery in flight keeps the old webhook while the new config applies.
+	send(conn, "in flight")
+	assert.Eventually(t, func() bool {
+		_, stats := plugin.stats.snapshot()
+		return stats["chat"] != nil && stats["chat"].Sent == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.NoError(t, plugin.ValidateAndSetConfig(newConfig("first", newWebhook.URL)))
+	close(release)
+	assert.Equal(t, "in flight", receive(oldReceived))
+
+	// The stream wasn't reconnected, new messages go to the new webhook.
+	send(conn, "after reload")
+	assert.Equal(t, "after reload", receive(newReceived))
+	assert.Empty(t, tokens)
+
+	// A new client token reconnects the stream.
+	assert.NoError(t, plugin.ValidateAndSetConfig(newConfig("second", newWebhook.URL)))
+	select {
+	case token := <-tokens:
+		assert.Equal(t, "second", token)
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream was not reconnected")
+	}
+	send(<-conns, "reconnected")
+	assert.Equal(t, "reconnected", receive(newReceived))
+	assert.Eventually(t, func() bool {
+		_, stats := plugin.stats.snapshot()
+		return stats["chat"].Succeeded == 3 && stats["chat"].Failed == 0
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// Invalid configs leave the active one untouched.
+	assert.Error(t, plugin.ValidateAndSetConfig(newConfig("third", "not a url")))
+	assert.Equal(t, "second", plugin.currentConfig().ClientToken)
+}
+
 func TestMultiNotifierPlugin_DefaultConfig(t *testing.T) {
 	plugin := &MultiNotifierPlugin{}
 	config := plugin.DefaultConfig()
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Modes of template previews.
+const (
+	// PreviewModeAuto renders JSON templates field by field and other templates as plain text,
+	// like bodies without body_format.
+	PreviewModeAuto  = ""
+	PreviewModePlain = "plain"
+	PreviewModeJSON  = "json"
+)
+
+// templatePositionPattern matches the position text/template reports in its errors, the column
+// is only known for execution errors.
+var templatePositionPattern = regexp.MustCompile(`template: [^:]*:(\d+)(?::(\d+))?: `)
+
+// templatePreview is the request body of the preview route, without a message a sample message is
+// used.
+type templatePreview struct {
+	Template string           `json:"template"`
+	Mode     string           `json:"mode"`
+	Message  *MessageExternal `json:"message"`
+}
+
+// templatePreviewError is a failed preview, the position is 1-based and relative to the field of
+// JSON templates.
+type templatePreviewError struct {
+	Error  string `json:"error"`
+	Field  string `json:"field,omitempty"`
+	Line   int    `json:"line,omitempty"`
+	Column int    `json:"column,omitempty"`
+}
+
+// renderPreview renders the template for the message in the mode.
+func renderPreview(preview *templatePreview, msg *MessageExternal) (string, error) {
+	switch preview.Mode {
+	case PreviewModePlain:
+		return processTemplateString(preview.Template, msg)
+	case PreviewModeJSON:
+		return processJSONBody(preview.Template, templateData(msg))
+	default:
+		return processBodyTemplate(preview.Template, templateData(msg))
+	}
+}
+
+// newTemplatePreviewError locates the error in the template.
+func newTemplatePreviewError(template string, err error) *templatePreviewError {
+	previewErr := &templatePreviewError{Error: err.Error()}
+
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		// The offset is the one of the invalid character.
+		before := template[:syntaxErr.Offset]
+		previewErr.Line = strings.Count(before, "\n") + 1
+		previewErr.Column = len(before) - strings.LastIndex(before, "\n") - 1
+		return previewErr
+	}
+
+	var fieldErr *templateFieldError
+	if errors.As(err, &fieldErr) {
+		previewErr.Field = fieldErr.field
+	}
+	if match := templatePositionPattern.FindStringSubmatch(err.Error()); match != nil {
+		previewErr.Line, _ = strconv.Atoi(match[1])
+		previewErr.Column, _ = strconv.Atoi(match[2])
+	}
+	return previewErr
+}
+
+func (p *MultiNotifierPlugin) handlePreviewTemplate(c *gin.Context) {
+	config := p.currentConfig()
+	if config == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "plugin is not configured"})
+		return
+	}
+	if !authorizeToken(c, config.APIToken) {
+		return
+	}
+
+	preview := &templatePreview{}
+	if err := json.NewDecoder(io.LimitReader(c.Request.Body, maxInboundBody)).Decode(preview); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body: " + err.Error()})
+		return
+	}
+	switch preview.Mode {
+	case PreviewModeAuto, PreviewModePlain, PreviewModeJSON:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unsupported mode %q", preview.Mode)})
+		return
+	}
+	msg := preview.Message
+	if msg == nil {
+		msg = sampleMessage(&WebHook{})
+	}
+	if apps := p.currentApps(); apps != nil {
+		msg.app, _ = apps.get(c.Request.Context(), msg.ApplicationID)
+	}
+	relayID, err := p.relayID()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	msg.relay = nextRelay(relayID, []*MessageExternal{msg}).String()
+
+	output, err := renderPreview(preview, msg)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, newTemplatePreviewError(preview.Template, err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"output": output})
+}
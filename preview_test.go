@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewTemplatePreviewError(t *testing.T) {
+	msg := &MessageExternal{Title: "a"}
+	for _, tt := range []struct {
+		preview  *templatePreview
+		expected *templatePreviewError
+	}{
+		{
+			preview:  &templatePreview{Template: "line\n{{.title}"},
+			expected: &templatePreviewError{Line: 2},
+		},
+		{
+			preview:  &templatePreview{Template: "{{.title}} {{index .extras 1}}", Mode: PreviewModePlain},
+			expected: &templatePreviewError{Line: 1, Column: 13},
+		},
+		{
+			preview:  &templatePreview{Template: `{"blocks": [{"text": "{{.title}} {{index .extras 1}}"}]}`},
+			expected: &templatePreviewError{Field: "blocks[0].text", Line: 1, Column: 13},
+		},
+		{
+			preview:  &templatePreview{Template: "{\n  \"text\": x\n}", Mode: PreviewModeJSON},
+			expected: &templatePreviewError{Line: 2, Column: 11},
+		},
+	} {
+		_, err := renderPreview(tt.preview, msg)
+		if !assert.Error(t, err, tt.preview.Template) {
+			continue
+		}
+		previewErr := newTemplatePreviewError(tt.preview.Template, err)
+		assert.Equal(t, err.Error(), previewErr.Error)
+		previewErr.Error = ""
+		assert.Equal(t, tt.expected, previewErr, err.Error())
+	}
+}
+
+func TestHandlePreviewTemplate(t *testing.T) {
+	p := &MultiNotifierPlugin{config: &Config{APIToken: "secret"}}
+	router := newTestRouter(p)
+
+	preview := func(body string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(http.MethodPost, "/plugin/1/custom/xyz/templates/preview", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var res map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &res)
+		return w.Code, res
+	}
+
+	code, res := preview(`{"template": "{\"text\": \"{{.title}}\"}", "mode": "json", "message": {"title": "Say \"hi\""}}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, `{"text":"Say \"hi\""}`, res["output"])
+
+	code, res = preview(`{"template": "{{.title}} ({{.priority}})"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Test message (5)", res["output"])
+
+	code, res = preview(`{"template": "{{.title"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+	assert.Contains(t, res["error"], "unclosed action")
+	assert.Equal(t, float64(1), res["line"])
+
+	code, _ = preview(`{"template": "x", "mode": "xml"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
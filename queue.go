@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+// deliveryQueueSize is the number of messages waiting for a webhook before new ones are dropped.
+const deliveryQueueSize = 100
+
+// queuedDelivery is a message waiting in a delivery queue, ctx carries the span of its receipt.
+type queuedDelivery struct {
+	ctx context.Context
+	msg *MessageExternal
+}
+
+// deliveryQueue delivers the messages of a webhook one after the other in the background, so slow
+// webhooks and their retries hold up neither the message stream nor the other webhooks. A nil
+// queue or one without a running worker delivers nothing, the caller delivers the message itself.
+type deliveryQueue struct {
+	items chan queuedDelivery
+
+	mu      sync.Mutex
+	running bool
+	closed  bool
+	done    chan struct{}
+	// worker identifies the current worker, the one of a previous Enable may still be exiting.
+	worker int
+}
+
+func newDeliveryQueue() *deliveryQueue {
+	return &deliveryQueue{
+		items: make(chan queuedDelivery, deliveryQueueSize),
+		done:  make(chan struct{}),
+	}
+}
+
+// push queues the message. It returns false if no worker is running, and an error if the queue
+// is full.
+func (q *deliveryQueue) push(ctx context.Context, msg *MessageExternal) (bool, error) {
+	if q == nil {
+		return false, nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.running {
+		return false, nil
+	}
+	select {
+	case q.items <- queuedDelivery{ctx: ctx, msg: msg}:
+		return true, nil
+	default:
+		return true, fmt.Errorf("delivery queue is full, %d messages are waiting", deliveryQueueSize)
+	}
+}
+
+func (q *deliveryQueue) size() int {
+	if q == nil {
+		return 0
+	}
+	return len(q.items)
+}
+
+// close stops accepting messages once the queued ones are delivered, the worker exits then.
+func (q *deliveryQueue) close() {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		q.closed = true
+		close(q.done)
+	}
+}
+
+// start marks a new worker as running and returns its ID, ok is false if the queue is closed.
+func (q *deliveryQueue) start() (int, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return 0, false
+	}
+	q.worker++
+	q.running = true
+	return q.worker, true
+}
+
+// next returns the next queued message for the worker. ok is false once the queue is closed and
+// empty, or ctx is done, messages still queued then are discarded.
+func (q *deliveryQueue) next(ctx context.Context, worker int) (queuedDelivery, bool) {
+	select {
+	case <-ctx.Done():
+		q.stop(worker)
+		return queuedDelivery{}, false
+	case d := <-q.items:
+		return d, true
+	case <-q.done:
+	}
+
+	// Messages can't be queued meanwhile, so none is left behind.
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	select {
+	case d := <-q.items:
+		return d, true
+	default:
+		q.running = false
+		return queuedDelivery{}, false
+	}
+}
+
+// stop discards the queued messages of the disabled plugin, unless another worker took over.
+func (q *deliveryQueue) stop(worker int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.worker != worker {
+		return
+	}
+	q.running = false
+	for {
+		select {
+		case <-q.items:
+		default:
+			return
+		}
+	}
+}
+
+// queueDelivery delivers the message with the webhook's queue, or right away if it has no worker.
+func (p *MultiNotifierPlugin) queueDelivery(ctx context.Context, webhook *WebHook, msg *MessageExternal) error {
+	queued, err := webhook.queue.push(ctx, msg)
+	if err != nil {
+		p.stats.failed(webhook, err)
+		return fmt.Errorf("failed to queue message for %s: %w", webhook.Url, err)
+	}
+	if queued {
+		return nil
+	}
+	return p.deliverMessage(ctx, webhook, msg)
+}
+
+// startDeliveries delivers the queued messages of the webhooks in the background until ctx is done
+// or their queue is closed.
+func (p *MultiNotifierPlugin) startDeliveries(ctx context.Context, webhooks []*WebHook) {
+	for _, webhook := range webhooks {
+		q := webhook.queue
+		if q == nil {
+			continue
+		}
+		worker, ok := q.start()
+		if !ok {
+			continue
+		}
+
+		webhook := webhook
+		go func() {
+			for {
+				d, ok := q.next(ctx, worker)
+				if !ok {
+					return
+				}
+				if err := p.deliverMessage(d.ctx, webhook, d.msg); err != nil {
+					logger.Error("Failed to send message", slog.Any("error", err))
+				}
+			}
+		}()
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeliveryQueue(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+	received := make(chan string, 1)
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- string(body)
+	}))
+	defer fast.Close()
+
+	p := &MultiNotifierPlugin{}
+	assert.NoError(t, p.ValidateAndSetConfig(&Config{
+		HostServer: "ws://localhost",
+		WebHooks: []*WebHook{
+			{Name: "slow", Url: slow.URL, Body: "{{.title}}"},
+			{Name: "fast", Url: fast.URL, Body: "{{.title}}"},
+		},
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p.startDeliveries(ctx, p.config.WebHooks)
+
+	// A hung webhook holds up neither the message nor the other webhook.
+	done := make(chan []error)
+	go func() { done <- p.sendMessage(ctx, &MessageExternal{Title: "a"}, p.config.WebHooks) }()
+	select {
+	case errs := <-done:
+		assert.Empty(t, errs)
+	case <-time.After(time.Second):
+		t.Fatal("message was not queued")
+	}
+	assert.Equal(t, "a", <-received)
+
+	// Once the queue of the hung webhook is full, messages are dropped for it.
+	slowWebhook := p.config.WebHooks[0]
+	assert.Eventually(t, func() bool { return slowWebhook.queue.size() == 0 }, time.Second, 10*time.Millisecond)
+	for i := 0; i < deliveryQueueSize; i++ {
+		assert.NoError(t, p.queueDelivery(ctx, slowWebhook, &MessageExternal{Title: "b"}))
+	}
+	assert.ErrorContains(t, p.queueDelivery(ctx, slowWebhook, &MessageExternal{Title: "c"}), "queue is full")
+	assert.Equal(t, deliveryQueueSize, queueDepth(slowWebhook))
+}
+
+func TestDeliveryQueueClose(t *testing.T) {
+	received := make(chan string, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- string(body)
+	}))
+	defer server.Close()
+
+	webhook := &WebHook{Url: server.URL, Method: "POST", Body: "{{.title}}", queue: newDeliveryQueue()}
+	p := &MultiNotifierPlugin{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Without a worker messages are delivered right away.
+	assert.NoError(t, p.queueDelivery(ctx, webhook, &MessageExternal{Title: "direct"}))
+	assert.Equal(t, "direct", <-received)
+
+	p.startDeliveries(ctx, []*WebHook{webhook})
+	assert.NoError(t, p.queueDelivery(ctx, webhook, &MessageExternal{Title: "queued"}))
+	webhook.queue.close()
+	assert.Equal(t, "queued", <-received)
+	assert.Eventually(t, func() bool {
+		queued, _ := webhook.queue.push(ctx, &MessageExternal{})
+		return !queued
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// relayExtra carries the hop count and the plugin instances a relayed message passed through.
+const relayExtra = "webhook::relay"
+
+// Headers of outgoing requests, inbound routes turn them back into the relay extra.
+const (
+	relayHopsHeader = "X-Gotify-Webhook-Hops"
+	relayViaHeader  = "X-Gotify-Webhook-Via"
+)
+
+const defaultMaxHops = 3
+
+// relayInfo is the hop count and the instance IDs of the plugins a message was forwarded by.
+type relayInfo struct {
+	Hops int      `json:"hops"`
+	Via  []string `json:"via,omitempty"`
+}
+
+// messageRelay reads the relay extra of the message. The extra is either an object or, as written
+// by templates, its JSON encoding.
+func messageRelay(msg *MessageExternal) *relayInfo {
+	r := &relayInfo{}
+	var data []byte
+	switch v := msg.Extras[relayExtra].(type) {
+	case nil:
+		return r
+	case string:
+		data = []byte(v)
+	default:
+		data, _ = json.Marshal(v)
+	}
+	if err := json.Unmarshal(data, r); err != nil {
+		logger.Warn("Invalid relay extra", slog.Uint64("id", uint64(msg.ID)), slog.Any("err", err))
+	}
+	return r
+}
+
+// requestRelay reads the relay headers of an inbound request.
+func requestRelay(req *http.Request) *relayInfo {
+	r := &relayInfo{}
+	r.Hops, _ = strconv.Atoi(req.Header.Get(relayHopsHeader))
+	for _, id := range strings.Split(req.Header.Get(relayViaHeader), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			r.Via = append(r.Via, id)
+		}
+	}
+	return r
+}
+
+func (r *relayInfo) passedThrough(id string) bool {
+	for _, via := range r.Via {
+		if via == id {
+			return true
+		}
+	}
+	return false
+}
+
+// nextRelay returns the relay of the messages after they were forwarded by the instance.
+func nextRelay(id string, msgs []*MessageExternal) *relayInfo {
+	next := &relayInfo{}
+	for _, msg := range msgs {
+		r := messageRelay(msg)
+		if r.Hops > next.Hops {
+			next.Hops = r.Hops
+		}
+		for _, via := range r.Via {
+			if !next.passedThrough(via) {
+				next.Via = append(next.Via, via)
+			}
+		}
+	}
+	next.Hops++
+	if !next.passedThrough(id) {
+		next.Via = append(next.Via, id)
+	}
+	return next
+}
+
+func (r *relayInfo) setHeaders(header http.Header) {
+	header.Set(relayHopsHeader, strconv.Itoa(r.Hops))
+	header.Set(relayViaHeader, strings.Join(r.Via, ", "))
+}
+
+// String returns the JSON encoding of the relay for the relay extra.
+func (r *relayInfo) String() string {
+	data, _ := json.Marshal(r)
+	return string(data)
+}
+
+// isGotifyMessageURL reports whether the URL is the message API of a Gotify server: the path ends
+// with /message and the request carries an application token.
+func isGotifyMessageURL(webhook *WebHook) bool {
+	u, err := url.Parse(webhook.Url)
+	if err != nil || path.Base(u.Path) != "message" {
+		return false
+	}
+	if u.Query().Get("token") != "" {
+		return true
+	}
+	for k := range webhook.Header {
+		if http.CanonicalHeaderKey(k) == "X-Gotify-Key" {
+			return true
+		}
+	}
+	return false
+}
+
+// withRelayExtra adds the relay extra to JSON object bodies posted to the message API of a Gotify
+// server, so its plugins recognize the message as relayed. Bodies which set the extra already, e.g.
+// with the relay placeholder, and all other bodies are returned unchanged.
+func withRelayExtra(webhook *WebHook, body string, relay *relayInfo) string {
+	switch webhook.BodyFormat {
+	case BodyFormatAuto, BodyFormatJSON:
+	default:
+		return body
+	}
+	if !isGotifyMessageURL(webhook) {
+		return body
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(body), &object); err != nil || object == nil {
+		return body
+	}
+	extras, ok := object["extras"].(map[string]interface{})
+	if !ok {
+		if object["extras"] != nil {
+			return body
+		}
+		extras = make(map[string]interface{})
+		object["extras"] = extras
+	}
+	if _, ok := extras[relayExtra]; ok {
+		return body
+	}
+	extras[relayExtra] = relay
+	data, err := json.Marshal(object)
+	if err != nil {
+		return body
+	}
+	return string(data)
+}
+
+// relayID returns the random ID of the plugin instance in relayed messages.
+func (p *MultiNotifierPlugin) relayID() (string, error) {
+	p.relayMu.Lock()
+	defer p.relayMu.Unlock()
+	if p.relayInstance == "" {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			return "", fmt.Errorf("failed to generate relay id: %w", err)
+		}
+		p.relayInstance = hex.EncodeToString(b)
+	}
+	return p.relayInstance, nil
+}
+
+func (p *MultiNotifierPlugin) maxHops() int {
+	config := p.currentConfig()
+	if config == nil || config.MaxHops == 0 {
+		return defaultMaxHops
+	}
+	return config.MaxHops
+}
+
+// loopReason returns why the relay must not be forwarded again by the instance with the ID, or an
+// empty string.
+func (p *MultiNotifierPlugin) loopReason(id string, r *relayInfo) string {
+	if r.passedThrough(id) {
+		return "already forwarded by this plugin"
+	}
+	if r.Hops >= p.maxHops() {
+		return "hop limit reached"
+	}
+	return ""
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNextRelay(t *testing.T) {
+	msgs := []*MessageExternal{
+		{Extras: map[string]interface{}{relayExtra: map[string]interface{}{"hops": float64(1), "via": []interface{}{"a"}}}},
+		{Extras: map[string]interface{}{relayExtra: `{"hops": 2, "via": ["a", "b"]}`}},
+		{},
+	}
+	assert.Equal(t, &relayInfo{Hops: 3, Via: []string{"a", "b", "c"}}, nextRelay("c", msgs))
+	assert.Equal(t, &relayInfo{Hops: 1, Via: []string{"c"}}, nextRelay("c", nil))
+}
+
+func TestRelayLoop(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		headers []http.Header
+		bodies  []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		headers = append(headers, r.Header)
+		bodies = append(bodies, string(body))
+	}))
+	defer server.Close()
+
+	p := &MultiNotifierPlugin{}
+	assert.Error(t, p.ValidateAndSetConfig(&Config{HostServer: "ws://localhost", MaxHops: -1}))
+	assert.NoError(t, p.ValidateAndSetConfig(&Config{
+		HostServer: "ws://localhost",
+		MaxHops:    2,
+		WebHooks: []*WebHook{{
+			Url:        server.URL,
+			BodyFormat: BodyFormatJSON,
+			Body:       `{"title": "{{.title}}", "extras": {"webhook::relay": "{{.relay}}"}}`,
+		}},
+	}))
+
+	id, err := p.relayID()
+	assert.NoError(t, err)
+	ctx := context.Background()
+	assert.Empty(t, p.sendMessage(ctx, &MessageExternal{ID: 1, Title: "a"}, p.config.WebHooks))
+	if assert.Len(t, headers, 1) {
+		assert.Equal(t, "1", headers[0].Get(relayHopsHeader))
+		assert.Equal(t, id, headers[0].Get(relayViaHeader))
+	}
+
+	// The forwarded message posted back to Gotify isn't forwarded again.
+	var echo MessageExternal
+	assert.NoError(t, json.Unmarshal([]byte(bodies[0]), &echo))
+	assert.Empty(t, p.sendMessage(ctx, &echo, p.config.WebHooks))
+
+	// Messages relayed by other servers are forwarded until the hop limit.
+	assert.Empty(t, p.sendMessage(ctx, &MessageExternal{ID: 2, Extras: map[string]interface{}{relayExtra: `{"hops": 1, "via": ["other"]}`}}, p.config.WebHooks))
+	assert.Empty(t, p.sendMessage(ctx, &MessageExternal{ID: 3, Extras: map[string]interface{}{relayExtra: `{"hops": 2, "via": ["other"]}`}}, p.config.WebHooks))
+	if assert.Len(t, headers, 2) {
+		assert.Equal(t, "2", headers[1].Get(relayHopsHeader))
+		assert.Equal(t, "other, "+id, headers[1].Get(relayViaHeader))
+	}
+}
+
+func TestHandleInboundRelay(t *testing.T) {
+	handler := &recordingMessageHandler{}
+	p := &MultiNotifierPlugin{config: &Config{Inbound: []*InboundRoute{{Name: "bridge", Message: "$.text"}}}}
+	p.SetMessageHandler(handler)
+	router := newTestRouter(p)
+	id, err := p.relayID()
+	assert.NoError(t, err)
+
+	post := func(hops, via string) int {
+		req := httptest.NewRequest(http.MethodPost, "/plugin/1/custom/xyz/inbound/bridge", strings.NewReader(`{"text": "hi"}`))
+		req.Header.Set(relayHopsHeader, hops)
+		req.Header.Set(relayViaHeader, via)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusLoopDetected, post("1", id))
+	assert.Equal(t, http.StatusLoopDetected, post("3", "a, b, c"))
+	assert.Equal(t, http.StatusOK, post("1", "other"))
+
+	sent := handler.sent()
+	if assert.Len(t, sent, 1) {
+		msg := &MessageExternal{Extras: sent[0].Extras}
+		assert.Equal(t, &relayInfo{Hops: 1, Via: []string{"other"}}, messageRelay(msg))
+	}
+}
+
+func TestWithRelayExtra(t *testing.T) {
+	relay := &relayInfo{Hops: 1, Via: []string{"a"}}
+	gotify := &WebHook{Url: "https://gotify.example.com/message?token=app", BodyFormat: BodyFormatJSON}
+	assert.JSONEq(t, `{"title": "t", "extras": {"webhook::relay": {"hops": 1, "via": ["a"]}}}`,
+		withRelayExtra(gotify, `{"title": "t"}`, relay))
+	assert.JSONEq(t, `{"extras": {"client::display": {}, "webhook::relay": {"hops": 1, "via": ["a"]}}}`,
+		withRelayExtra(gotify, `{"extras": {"client::display": {}}}`, relay))
+
+	// Bodies which set the extra themselves are kept.
+	body := `{"extras":{"webhook::relay":"{\"hops\":1}"}}`
+	assert.Equal(t, body, withRelayExtra(gotify, body, relay))
+	assert.Equal(t, `["t"]`, withRelayExtra(gotify, `["t"]`, relay))
+
+	header := &WebHook{Url: "https://gotify.example.com/gotify/message", BodyFormat: BodyFormatJSON, Header: map[string]string{"x-gotify-key": "app"}}
+	assert.Contains(t, withRelayExtra(header, `{}`, relay), relayExtra)
+
+	// JSON bodies with the default body format get the extra as well, text bodies are kept.
+	auto := &WebHook{Url: "https://gotify.example.com/message?token=app"}
+	assert.JSONEq(t, `{"title": "t", "extras": {"webhook::relay": {"hops": 1, "via": ["a"]}}}`,
+		withRelayExtra(auto, `{"title": "t"}`, relay))
+	assert.Equal(t, "t", withRelayExtra(auto, "t", relay))
+
+	for _, webhook := range []*WebHook{
+		{Url: "https://gotify.example.com/message", BodyFormat: BodyFormatJSON},
+		{Url: "https://chat.example.com/api/send?token=app", BodyFormat: BodyFormatJSON},
+		{Url: "https://gotify.example.com/message?token=app", BodyFormat: BodyFormatText},
+	} {
+		assert.Equal(t, `{}`, withRelayExtra(webhook, `{}`, relay), webhook.Url)
+	}
+}
+
+func TestRelayLoopDefaultBodyFormat(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		bodies []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		bodies = append(bodies, string(body))
+	}))
+	defer server.Close()
+
+	// The webhook posts to the message API of its own Gotify server with a plain JSON body.
+	p := &MultiNotifierPlugin{}
+	assert.NoError(t, p.ValidateAndSetConfig(&Config{
+		HostServer: "ws://localhost",
+		WebHooks: []*WebHook{{
+			Url:  server.URL + "/message?token=app",
+			Body: `{"title": "{{.title}}", "message": "{{.message}}"}`,
+		}},
+	}))
+
+	ctx := context.Background()
+	assert.Empty(t, p.sendMessage(ctx, &MessageExternal{ID: 1, Title: "a", Message: "b"}, p.config.WebHooks))
+	if !assert.Len(t, bodies, 1) {
+		return
+	}
+	var echo MessageExternal
+	assert.NoError(t, json.Unmarshal([]byte(bodies[0]), &echo))
+	assert.Equal(t, "a", echo.Title)
+	assert.Equal(t, 1, messageRelay(&echo).Hops)
+
+	assert.Empty(t, p.sendMessage(ctx, &echo, p.config.WebHooks))
+	assert.Len(t, bodies, 1)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gotify/plugin-api"
+)
+
+const (
+	defaultFailureInterval         = 15 * time.Minute
+	defaultFailurePriority         = priorityCritical
+	defaultFailureRecoveryPriority = priorityInfo
+)
+
+// notificationExtra marks the messages the plugin sends itself, they are never forwarded.
+const notificationExtra = "webhook::notification"
+
+// FailureNotifications reports webhooks failing permanently, i.e. after all retries, as Gotify
+// messages and when they recover.
+type FailureNotifications struct {
+	// Interval is the minimum time between two failure messages of a webhook.
+	Interval         Duration `yaml:"interval"`
+	Priority         *int     `yaml:"priority"`
+	RecoveryPriority *int     `yaml:"recovery_priority"`
+}
+
+func (n *FailureNotifications) compile() error {
+	if n == nil {
+		return nil
+	}
+	if n.Interval < 0 {
+		return fmt.Errorf("interval must not be negative")
+	}
+	if n.Interval == 0 {
+		n.Interval = Duration(defaultFailureInterval)
+	}
+	if n.Priority == nil {
+		priority := defaultFailurePriority
+		n.Priority = &priority
+	}
+	if n.RecoveryPriority == nil {
+		priority := defaultFailureRecoveryPriority
+		n.RecoveryPriority = &priority
+	}
+	return nil
+}
+
+// failureState tracks a failing webhook.
+type failureState struct {
+	since time.Time
+	// failures counts the failures since the webhook started failing, reported up to the last report.
+	failures, reported int
+	lastReport         time.Time
+}
+
+// failureReporter sends the failure and recovery messages of webhooks.
+type failureReporter struct {
+	mu     sync.Mutex
+	failed map[string]*failureState
+}
+
+// isNotification reports whether the plugin sent the message itself.
+func isNotification(msg *MessageExternal) bool {
+	_, ok := msg.Extras[notificationExtra]
+	return ok
+}
+
+// reportDelivery sends a message if the webhook failed and the last report is older than the
+// interval, or if it succeeded after a reported failure. err is nil if the delivery succeeded.
+func (p *MultiNotifierPlugin) reportDelivery(webhook *WebHook, err error) {
+	var config *FailureNotifications
+	if active := p.currentConfig(); active != nil {
+		config = active.FailureNotifications
+	}
+	if config == nil || p.msgHandler == nil {
+		return
+	}
+	// Deliveries are canceled when the plugin is disabled.
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	msg := p.failures.track(webhook, err, time.Now(), time.Duration(config.Interval))
+	if msg == nil {
+		return
+	}
+
+	msg.Priority = *config.Priority
+	if err == nil {
+		msg.Priority = *config.RecoveryPriority
+	}
+	msg.Extras = map[string]interface{}{
+		notificationExtra: map[string]interface{}{"webhook": webhookLabel(webhook)},
+	}
+	if err := p.msgHandler.SendMessage(*msg); err != nil {
+		logger.Error("Failed to send failure notification", slog.String("webhook", webhook.Url), slog.Any("err", err))
+	}
+}
+
+// track updates the state of the webhook and returns the message to report, if any.
+func (r *failureReporter) track(webhook *WebHook, err error, now time.Time, interval time.Duration) *plugin.Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failed == nil {
+		r.failed = make(map[string]*failureState)
+	}
+	key := webhookKey(webhook)
+	state := r.failed[key]
+	label := webhookLabel(webhook)
+
+	if err == nil {
+		if state == nil {
+			return nil
+		}
+		delete(r.failed, key)
+		if state.reported == 0 {
+			return nil
+		}
+		return &plugin.Message{
+			Title: fmt.Sprintf("Webhook %s recovered", label),
+			Message: fmt.Sprintf("Deliveries to %s succeed again after %d failures since %s.",
+				label, state.failures, state.since.Local().Format(statusTimeFormat)),
+		}
+	}
+
+	if state == nil {
+		state = &failureState{since: now}
+		r.failed[key] = state
+	}
+	state.failures++
+	if !state.lastReport.IsZero() && now.Sub(state.lastReport) < interval {
+		return nil
+	}
+
+	message := fmt.Sprintf("Delivery to %s failed: %s", label, maskSecrets(err.Error()))
+	if missed := state.failures - state.reported - 1; missed > 0 {
+		message += fmt.Sprintf("\n\n%d more failures since the last report.", missed)
+	}
+	state.reported = state.failures
+	state.lastReport = now
+	return &plugin.Message{Title: fmt.Sprintf("Webhook %s is failing", label), Message: message}
+}
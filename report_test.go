@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFailureReporterTrack(t *testing.T) {
+	r := &failureReporter{}
+	webhook := &WebHook{Name: "chat", Url: "https://chat.example.com"}
+	now := time.Now()
+	interval := 15 * time.Minute
+
+	msg := r.track(webhook, errors.New("unexpected status code: 503"), now, interval)
+	if assert.NotNil(t, msg) {
+		assert.Equal(t, "Webhook chat is failing", msg.Title)
+		assert.Equal(t, "Delivery to chat failed: unexpected status code: 503", msg.Message)
+	}
+
+	// Further failures are only reported once per interval.
+	assert.Nil(t, r.track(webhook, errors.New("timeout"), now.Add(time.Minute), interval))
+	msg = r.track(webhook, errors.New("timeout"), now.Add(16*time.Minute), interval)
+	if assert.NotNil(t, msg) {
+		assert.Equal(t, "Delivery to chat failed: timeout\n\n1 more failures since the last report.", msg.Message)
+	}
+
+	msg = r.track(webhook, nil, now.Add(20*time.Minute), interval)
+	if assert.NotNil(t, msg) {
+		assert.Equal(t, "Webhook chat recovered", msg.Title)
+		assert.Contains(t, msg.Message, "succeed again after 3 failures")
+	}
+	assert.Nil(t, r.track(webhook, nil, now.Add(21*time.Minute), interval))
+}
+
+func TestReportDelivery(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= 2 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	handler := &recordingMessageHandler{}
+	p := &MultiNotifierPlugin{}
+	p.SetMessageHandler(handler)
+	assert.Error(t, p.ValidateAndSetConfig(&Config{
+		HostServer:           "ws://localhost",
+		FailureNotifications: &FailureNotifications{Interval: -1},
+	}))
+	assert.NoError(t, p.ValidateAndSetConfig(&Config{
+		HostServer:           "ws://localhost",
+		FailureNotifications: &FailureNotifications{RecoveryPriority: intPtr(0)},
+		WebHooks:             []*WebHook{{Name: "chat", Url: server.URL}},
+	}))
+
+	ctx := context.Background()
+	assert.Len(t, p.sendMessage(ctx, &MessageExternal{ApplicationID: 1, Title: "a"}, p.config.WebHooks), 1)
+	assert.Len(t, p.sendMessage(ctx, &MessageExternal{ApplicationID: 1, Title: "b"}, p.config.WebHooks), 1)
+	sent := handler.sent()
+	if assert.Len(t, sent, 1) {
+		assert.Equal(t, "Webhook chat is failing", sent[0].Title)
+		assert.Equal(t, defaultFailurePriority, sent[0].Priority)
+		assert.Contains(t, sent[0].Extras, notificationExtra)
+	}
+
+	// The notification arriving from the stream isn't forwarded.
+	notification := &MessageExternal{ApplicationID: 5, Title: sent[0].Title, Message: sent[0].Message, Extras: sent[0].Extras}
+	assert.Empty(t, p.sendMessage(ctx, notification, p.config.WebHooks))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	assert.Empty(t, p.sendMessage(ctx, &MessageExternal{ApplicationID: 1, Title: "c"}, p.config.WebHooks))
+	sent = handler.sent()
+	if assert.Len(t, sent, 2) {
+		assert.Equal(t, "Webhook chat recovered", sent[1].Title)
+		assert.Equal(t, 0, sent[1].Priority)
+	}
+}
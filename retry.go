@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultRetryBackoff = time.Second
+	// maxRetryAfter is the longest Retry-After waited for, requests asking for more aren't retried.
+	maxRetryAfter = 5 * time.Minute
+)
+
+// Retry repeats failed requests of a webhook. Requests are retried after network errors, server
+// errors and `429 Too Many Requests`, the backoff doubles after every attempt. A longer Retry-After
+// of the response is honored.
+type Retry struct {
+	// Attempts is the maximum number of attempts including the first one.
+	Attempts int      `yaml:"attempts"`
+	Backoff  Duration `yaml:"backoff"`
+}
+
+// statusError is a response with an unexpected status code.
+type statusError struct {
+	code int
+	// retryAfter is the delay requested with the Retry-After header.
+	retryAfter time.Duration
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("unexpected status code: %d", e.code)
+}
+
+func (r *Retry) compile() error {
+	if r == nil {
+		return nil
+	}
+	if r.Attempts < 1 {
+		return fmt.Errorf("attempts must be at least 1")
+	}
+	if r.Backoff < 0 {
+		return fmt.Errorf("backoff must not be negative")
+	}
+	if r.Backoff == 0 {
+		r.Backoff = Duration(defaultRetryBackoff)
+	}
+	return nil
+}
+
+// parseRetryAfter parses the delay in seconds or the HTTP date of a Retry-After header.
+func parseRetryAfter(header string, now time.Time) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(header); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var status *statusError
+	if errors.As(err, &status) {
+		return status.code >= 500 || status.code == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// do calls attempt until it succeeds, fails permanently or the attempts are exhausted. onRetry is
+// called before every repeated attempt.
+func (r *Retry) do(ctx context.Context, attempt func() error, onRetry func()) error {
+	err := attempt()
+	if r == nil {
+		return err
+	}
+
+	backoff := time.Duration(r.Backoff)
+	for i := 1; i < r.Attempts && err != nil && retryable(err); i++ {
+		wait := backoff
+		var status *statusError
+		if errors.As(err, &status) && status.retryAfter > wait {
+			if status.retryAfter > maxRetryAfter {
+				return err
+			}
+			wait = status.retryAfter
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		backoff *= 2
+
+		onRetry()
+		err = attempt()
+	}
+	return err
+}
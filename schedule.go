@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Supported values of Schedule.OutOfWindow.
+const (
+	OutOfWindowDrop  = "drop"
+	OutOfWindowDefer = "defer"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Schedule restricts deliveries of a webhook to time windows.
+type Schedule struct {
+	TimeZone string        `yaml:"time_zone"`
+	Windows  []*TimeWindow `yaml:"windows"`
+	// OutOfWindow is either drop or defer, messages are deferred until the next window opens.
+	OutOfWindow string `yaml:"out_of_window"`
+	// BypassPriority is the minimum priority of messages sent regardless of the windows.
+	BypassPriority *int `yaml:"bypass_priority"`
+
+	location *time.Location
+
+	mu sync.Mutex
+	// deferred are the messages waiting for the next window.
+	deferred map[*deferredMessage]bool
+	// closed is set once the deferred messages were handed over to a reloaded config.
+	closed bool
+}
+
+// deferredMessage is a message waiting for the next window, cancel stops its timer.
+type deferredMessage struct {
+	ctx    context.Context
+	msg    *MessageExternal
+	cancel chan struct{}
+}
+
+// TimeWindow is a daily time range on some days of the week. A range whose end is before its
+// start spans midnight, e.g. 22:00-06:00 on mon lasts until tuesday morning.
+type TimeWindow struct {
+	Days []string `yaml:"days"`
+	From string   `yaml:"from"`
+	To   string   `yaml:"to"`
+
+	days     [7]bool
+	from, to time.Duration
+}
+
+func (s *Schedule) compile() error {
+	if s == nil {
+		return nil
+	}
+
+	location, err := time.LoadLocation(s.TimeZone)
+	if err != nil {
+		return fmt.Errorf("invalid time_zone %q: %w", s.TimeZone, err)
+	}
+	s.location = location
+
+	switch s.OutOfWindow {
+	case "":
+		s.OutOfWindow = OutOfWindowDrop
+	case OutOfWindowDrop, OutOfWindowDefer:
+	default:
+		return fmt.Errorf("unsupported out_of_window %q", s.OutOfWindow)
+	}
+
+	if len(s.Windows) == 0 {
+		return fmt.Errorf("at least one window is required")
+	}
+	for _, window := range s.Windows {
+		if err := window.compile(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (w *TimeWindow) compile() (err error) {
+	w.days = [7]bool{}
+	if len(w.Days) == 0 {
+		for i := range w.days {
+			w.days[i] = true
+		}
+	}
+	for _, day := range w.Days {
+		weekday, ok := weekdays[strings.ToLower(day)]
+		if !ok {
+			return fmt.Errorf("invalid day %q", day)
+		}
+		w.days[weekday] = true
+	}
+
+	if w.from, err = parseTimeOfDay(w.From, 0); err != nil {
+		return err
+	}
+	if w.to, err = parseTimeOfDay(w.To, 24*time.Hour); err != nil {
+		return err
+	}
+	if w.from == w.to {
+		return fmt.Errorf("window %s-%s is empty", w.From, w.To)
+	}
+	return nil
+}
+
+// parseTimeOfDay parses HH:MM into the duration since midnight.
+func parseTimeOfDay(s string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		if s == "24:00" {
+			return 24 * time.Hour, nil
+		}
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// windowStart returns the start and end of the window starting on the day of t.
+func (w *TimeWindow) windowStart(t time.Time) (start, end time.Time, ok bool) {
+	if !w.days[t.Weekday()] {
+		return time.Time{}, time.Time{}, false
+	}
+	// Times of day are wall clock times, adding durations to midnight would shift them on DST days.
+	start = timeOfDay(t, w.from)
+	end = timeOfDay(t, w.to)
+	if w.to < w.from {
+		end = timeOfDay(t.AddDate(0, 0, 1), w.to)
+	}
+	return start, end, true
+}
+
+// timeOfDay returns the time on the day of t at the wall clock time d after midnight.
+func timeOfDay(t time.Time, d time.Duration) time.Time {
+	minutes := int(d / time.Minute)
+	return time.Date(t.Year(), t.Month(), t.Day(), minutes/60, minutes%60, 0, 0, t.Location())
+}
+
+// Active reports whether t is within one of the windows.
+func (s *Schedule) Active(t time.Time) bool {
+	t = t.In(s.location)
+	for _, w := range s.Windows {
+		// Windows spanning midnight may have started the day before.
+		for _, day := range []time.Time{t, t.AddDate(0, 0, -1)} {
+			start, end, ok := w.windowStart(day)
+			if ok && !t.Before(start) && t.Before(end) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NextStart returns the time the next window opens after t.
+func (s *Schedule) NextStart(t time.Time) time.Time {
+	t = t.In(s.location)
+	var next time.Time
+	for i := 0; i <= 7; i++ {
+		day := t.AddDate(0, 0, i)
+		for _, w := range s.Windows {
+			start, _, ok := w.windowStart(day)
+			if ok && start.After(t) && (next.IsZero() || start.Before(next)) {
+				next = start
+			}
+		}
+		if !next.IsZero() {
+			return next
+		}
+	}
+	return next
+}
+
+// hold adds the message to the deferred ones, it returns false if the schedule is closed.
+func (s *Schedule) hold(d *deferredMessage) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	if s.deferred == nil {
+		s.deferred = make(map[*deferredMessage]bool)
+	}
+	s.deferred[d] = true
+	return true
+}
+
+// release removes the message from the deferred ones, it returns false if it was handed over.
+func (s *Schedule) release(d *deferredMessage) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.deferred[d] {
+		return false
+	}
+	delete(s.deferred, d)
+	return true
+}
+
+// size returns the number of deferred messages.
+func (s *Schedule) size() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.deferred)
+}
+
+// close stops the timers of the deferred messages and returns them, messages deferred later are
+// rejected by hold.
+func (s *Schedule) close() []*deferredMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	deferred := make([]*deferredMessage, 0, len(s.deferred))
+	for d := range s.deferred {
+		close(d.cancel)
+		deferred = append(deferred, d)
+	}
+	s.deferred = nil
+	return deferred
+}
+
+// deferMessage delivers the message to the webhook once its next window opens. If the config was
+// reloaded meanwhile, the message is forwarded to the webhook that replaced this one.
+func (p *MultiNotifierPlugin) deferMessage(ctx context.Context, webhook *WebHook, msg *MessageExternal) error {
+	d := &deferredMessage{ctx: ctx, msg: msg, cancel: make(chan struct{})}
+	if !webhook.Schedule.hold(d) {
+		return p.forwardDeferred(webhook, d)
+	}
+
+	at := webhook.Schedule.NextStart(time.Now())
+	logger.Info("Deferring message until the schedule window opens",
+		slog.String("webhook", webhook.Url), slog.Uint64("id", uint64(msg.ID)), slog.Time("until", at))
+
+	go func() {
+		timer := time.NewTimer(time.Until(at))
+		defer timer.Stop()
+
+		select {
+		case <-ctx.Done():
+			webhook.Schedule.release(d)
+			return
+		case <-d.cancel:
+			return
+		case <-timer.C:
+		}
+
+		// The message may have been handed over while the timer fired.
+		if !webhook.Schedule.release(d) {
+			return
+		}
+		if err := p.deliverMessage(ctx, webhook, msg); err != nil {
+			logger.Error("Failed to send deferred message", slog.Any("error", err))
+		}
+	}()
+	return nil
+}
+
+// forwardDeferred forwards a message deferred by a webhook of a replaced config to its successor,
+// which filters and defers it by its own rules. Messages of removed webhooks are sent right away.
+func (p *MultiNotifierPlugin) forwardDeferred(webhook *WebHook, d *deferredMessage) error {
+	if config := p.currentConfig(); config != nil {
+		key := webhookKey(webhook)
+		for _, next := range config.WebHooks {
+			if next != webhook && webhookKey(next) == key {
+				return p.forwardMessage(d.ctx, next, d.msg)
+			}
+		}
+	}
+	return p.queueDelivery(d.ctx, webhook, d.msg)
+}
+
+// handOverWebHooks moves the state kept by the webhooks of the old config to the ones replacing
+// them. Deferred messages are forwarded to the new webhooks, open dedup windows are discarded.
+func (p *MultiNotifierPlugin) handOverWebHooks(old []*WebHook) {
+	for _, webhook := range old {
+		webhook.Dedup.stop()
+		if webhook.Schedule == nil {
+			continue
+		}
+		for _, d := range webhook.Schedule.close() {
+			if err := p.forwardDeferred(webhook, d); err != nil {
+				logger.Error("Failed to forward deferred message", slog.Any("error", err))
+			}
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduleActive(t *testing.T) {
+	schedule := &Schedule{
+		TimeZone: "Europe/Berlin",
+		Windows: []*TimeWindow{
+			{Days: []string{"mon", "tue", "wed", "thu", "fri"}, From: "08:00", To: "18:00"},
+			{Days: []string{"Sat"}, From: "22:00", To: "02:00"},
+		},
+	}
+	assert.NoError(t, schedule.compile())
+	assert.Equal(t, OutOfWindowDrop, schedule.OutOfWindow)
+
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	testCases := []struct {
+		time   time.Time
+		active bool
+	}{
+		{time.Date(2024, 6, 3, 9, 0, 0, 0, berlin), true},   // Monday
+		{time.Date(2024, 6, 3, 18, 0, 0, 0, berlin), false}, // Monday, end is exclusive
+		{time.Date(2024, 6, 3, 7, 0, 0, 0, time.UTC), true}, // Monday 09:00 in Berlin
+		{time.Date(2024, 6, 8, 12, 0, 0, 0, berlin), false}, // Saturday
+		{time.Date(2024, 6, 8, 23, 0, 0, 0, berlin), true},  // Saturday night
+		{time.Date(2024, 6, 9, 1, 0, 0, 0, berlin), true},   // Sunday, window started on Saturday
+		{time.Date(2024, 6, 9, 22, 0, 0, 0, berlin), false}, // Sunday night
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.active, schedule.Active(tc.time), tc.time.String())
+	}
+
+	// From Saturday noon the next window opens Saturday night, from Sunday Monday morning.
+	assert.Equal(t, time.Date(2024, 6, 8, 22, 0, 0, 0, berlin), schedule.NextStart(time.Date(2024, 6, 8, 12, 0, 0, 0, berlin)))
+	assert.Equal(t, time.Date(2024, 6, 10, 8, 0, 0, 0, berlin), schedule.NextStart(time.Date(2024, 6, 9, 3, 0, 0, 0, berlin)))
+}
+
+func TestScheduleCompile(t *testing.T) {
+	assert.Error(t, (&Schedule{TimeZone: "Mars/Olympus", Windows: []*TimeWindow{{}}}).compile())
+	assert.Error(t, (&Schedule{}).compile())
+	assert.Error(t, (&Schedule{OutOfWindow: "queue", Windows: []*TimeWindow{{}}}).compile())
+	assert.Error(t, (&Schedule{Windows: []*TimeWindow{{Days: []string{"monday"}}}}).compile())
+	assert.Error(t, (&Schedule{Windows: []*TimeWindow{{From: "8am"}}}).compile())
+	assert.Error(t, (&Schedule{Windows: []*TimeWindow{{From: "08:00", To: "08:00"}}}).compile())
+	assert.NoError(t, (&Schedule{Windows: []*TimeWindow{{From: "20:00", To: "24:00"}}}).compile())
+}
+
+func TestForwardMessageOutOfSchedule(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer server.Close()
+
+	// A window which is never active right now.
+	now := time.Now().UTC()
+	from := now.Add(2 * time.Hour).Format("15:04")
+	to := now.Add(3 * time.Hour).Format("15:04")
+	webhook := &WebHook{
+		Url:      server.URL,
+		Method:   "POST",
+		Schedule: &Schedule{TimeZone: "UTC", Windows: []*TimeWindow{{From: from, To: to}}, BypassPriority: intPtr(8)},
+	}
+	assert.NoError(t, webhook.Schedule.compile())
+
+	plugin := &MultiNotifierPlugin{}
+	ctx := context.Background()
+
+	assert.NoError(t, plugin.forwardMessage(ctx, webhook, &MessageExternal{Priority: 5}))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&calls))
+
+	assert.NoError(t, plugin.forwardMessage(ctx, webhook, &MessageExternal{Priority: 8}))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestHandOverDeferredMessages(t *testing.T) {
+	received := make(chan string, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.URL.Path
+	}))
+	defer server.Close()
+
+	now := time.Now().UTC()
+	schedule := &Schedule{
+		TimeZone:    "UTC",
+		Windows:     []*TimeWindow{{From: now.Add(2 * time.Hour).Format("15:04"), To: now.Add(3 * time.Hour).Format("15:04")}},
+		OutOfWindow: OutOfWindowDefer,
+	}
+	assert.NoError(t, schedule.compile())
+	old := &WebHook{Name: "chat", Url: server.URL + "/old", Method: "POST", Schedule: schedule, Dedup: &Dedup{Window: Duration(time.Hour)}}
+	next := &WebHook{Name: "chat", Url: server.URL + "/new", Method: "POST"}
+
+	plugin := &MultiNotifierPlugin{config: &Config{WebHooks: []*WebHook{old}}}
+	ctx := context.Background()
+	assert.NoError(t, plugin.forwardMessage(ctx, old, &MessageExternal{ID: 1}))
+	assert.Equal(t, 1, queueDepth(old))
+	_, err := old.Dedup.suppress(&MessageExternal{ID: 2}, func(*MessageExternal, int) {})
+	assert.NoError(t, err)
+
+	// The deferred message moves to the new webhook, which has no schedule and sends it right away.
+	plugin.config = &Config{WebHooks: []*WebHook{next}}
+	plugin.handOverWebHooks([]*WebHook{old})
+	assert.Equal(t, "/new", <-received)
+	assert.Equal(t, 0, queueDepth(old))
+	assert.Empty(t, old.Dedup.entries)
+
+	// Messages deferred by the old webhook after the reload are forwarded as well.
+	assert.NoError(t, plugin.forwardMessage(ctx, old, &MessageExternal{ID: 3}))
+	assert.Equal(t, "/new", <-received)
+	assert.Empty(t, received)
+}
+
+func TestScheduleDST(t *testing.T) {
+	schedule := &Schedule{
+		TimeZone: "Europe/Berlin",
+		Windows:  []*TimeWindow{{From: "08:00", To: "18:00"}, {From: "22:00", To: "06:00"}},
+	}
+	assert.NoError(t, schedule.compile())
+
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	// Clocks moved forward on 2024-03-31 and back on 2024-10-27, the windows keep their wall times.
+	for _, date := range []time.Time{time.Date(2024, 3, 31, 0, 0, 0, 0, berlin), time.Date(2024, 10, 27, 0, 0, 0, 0, berlin)} {
+		year, month, day := date.Date()
+		assert.True(t, schedule.Active(time.Date(year, month, day, 8, 0, 0, 0, berlin)))
+		assert.False(t, schedule.Active(time.Date(year, month, day, 7, 59, 0, 0, berlin)))
+		assert.True(t, schedule.Active(time.Date(year, month, day, 17, 59, 0, 0, berlin)))
+		assert.False(t, schedule.Active(time.Date(year, month, day, 18, 0, 0, 0, berlin)))
+		assert.True(t, schedule.Active(time.Date(year, month, day, 5, 59, 0, 0, berlin)))
+		assert.False(t, schedule.Active(time.Date(year, month, day, 6, 0, 0, 0, berlin)))
+		assert.Equal(t, time.Date(year, month, day, 8, 0, 0, 0, berlin), schedule.NextStart(time.Date(year, month, day, 7, 0, 0, 0, berlin)))
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Environment variables of the Gotify server limiting the secret references. They are set by the
+// server admin, unlike the plugin config which every user can edit, so users can't read arbitrary
+// variables and files of the server. Without them no references are resolved.
+const (
+	envSecretEnvPrefix = "GOTIFY_WEBHOOK_SECRET_ENV_PREFIX"
+	envSecretDir       = "GOTIFY_WEBHOOK_SECRET_DIR"
+)
+
+// secretPattern matches the references `${env:NAME}` and `${file:/path}`, `$${` escapes a literal
+// `${`.
+var secretPattern = regexp.MustCompile(`\$\$\{|\$\{(env|file):([^}]*)\}`)
+
+// resolveSecrets returns a copy of the config with all secret references replaced by their values,
+// and the resolved values. The config itself keeps the references, so they are saved and shown in
+// the web console instead of the secrets.
+func resolveSecrets(config *Config) (*Config, []string, error) {
+	b, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	var node yaml.Node
+	if err := yaml.Unmarshal(b, &node); err != nil {
+		return nil, nil, err
+	}
+
+	var secrets []string
+	if err := resolveSecretNode(&node, &secrets); err != nil {
+		return nil, nil, err
+	}
+
+	resolved := &Config{}
+	if err := node.Decode(resolved); err != nil {
+		return nil, nil, fmt.Errorf("invalid config after resolving secrets: %w", err)
+	}
+	return resolved, secrets, nil
+}
+
+func resolveSecretNode(node *yaml.Node, secrets *[]string) error {
+	if node.Kind == yaml.ScalarNode && strings.Contains(node.Value, "${") {
+		var err error
+		value := secretPattern.ReplaceAllStringFunc(node.Value, func(ref string) string {
+			if ref == "$${" {
+				return "${"
+			}
+			m := secretPattern.FindStringSubmatch(ref)
+			secret, e := lookupSecret(m[1], m[2])
+			if e != nil {
+				if err == nil {
+					err = e
+				}
+				return ""
+			}
+			if secret != "" {
+				*secrets = append(*secrets, secret)
+			}
+			return secret
+		})
+		if err != nil {
+			return err
+		}
+		// The type of the value is resolved again, so numbers can be referenced as well.
+		node.Value, node.Tag, node.Style = value, "", 0
+	}
+	for _, child := range node.Content {
+		if err := resolveSecretNode(child, secrets); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func lookupSecret(kind, name string) (string, error) {
+	switch kind {
+	case "env":
+		prefix := os.Getenv(envSecretEnvPrefix)
+		if prefix == "" || !strings.HasPrefix(name, prefix) {
+			return "", fmt.Errorf("secret ${env:%s}: only variables starting with %s are allowed", name, envSecretEnvPrefix)
+		}
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("secret ${env:%s}: environment variable is not set", name)
+		}
+		return value, nil
+	default:
+		path, err := secretFilePath(name)
+		if err != nil {
+			return "", fmt.Errorf("secret ${file:%s}: %w", name, err)
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("secret ${file:%s}: %w", name, err)
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	}
+}
+
+// secretFilePath resolves the path of a file reference, which must be inside the secret directory
+// after resolving symlinks. Relative paths are relative to the directory.
+func secretFilePath(name string) (string, error) {
+	dir := os.Getenv(envSecretDir)
+	if dir == "" {
+		return "", fmt.Errorf("file references are disabled, %s is not set", envSecretDir)
+	}
+	dir, err := filepath.EvalSymlinks(filepath.Clean(dir))
+	if err != nil {
+		return "", fmt.Errorf("invalid %s: %w", envSecretDir, err)
+	}
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s: %w", envSecretDir, err)
+	}
+
+	path := filepath.Clean(name)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	path, err = filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("file is outside of %s", envSecretDir)
+	}
+	return path, nil
+}
+
+// secretRegistry holds the resolved secrets of the enabled plugin instances, which are masked in
+// logs. Instances register when they are enabled or reloaded and unregister when disabled.
+var secretRegistry = struct {
+	mu      sync.RWMutex
+	secrets map[*MultiNotifierPlugin][]string
+}{secrets: make(map[*MultiNotifierPlugin][]string)}
+
+func registerSecrets(p *MultiNotifierPlugin, secrets []string) {
+	secretRegistry.mu.Lock()
+	defer secretRegistry.mu.Unlock()
+	if len(secrets) == 0 {
+		delete(secretRegistry.secrets, p)
+		return
+	}
+	secretRegistry.secrets[p] = secrets
+}
+
+// maskSecrets replaces the resolved secrets of all enabled instances in s.
+func maskSecrets(s string) string {
+	secretRegistry.mu.RLock()
+	defer secretRegistry.mu.RUnlock()
+	for _, secrets := range secretRegistry.secrets {
+		s = maskSecretList(s, secrets)
+	}
+	return s
+}
+
+// maskSecretList replaces the secrets in s, e.g. those of a config while the plugin is disabled.
+func maskSecretList(s string, secrets []string) string {
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, "******")
+	}
+	return s
+}
+
+// logger is the logger of the plugin, it masks secrets before passing records to the default
+// logger.
+var logger = slog.New(&maskingHandler{})
+
+type maskingHandler struct {
+	// wrap applies the attributes and groups added with WithAttrs and WithGroup in order.
+	wrap []func(slog.Handler) slog.Handler
+}
+
+func (h *maskingHandler) next() slog.Handler {
+	next := slog.Default().Handler()
+	for _, wrap := range h.wrap {
+		next = wrap(next)
+	}
+	return next
+}
+
+func (h *maskingHandler) with(wrap func(slog.Handler) slog.Handler) slog.Handler {
+	return &maskingHandler{wrap: append(h.wrap[:len(h.wrap):len(h.wrap)], wrap)}
+}
+
+func (h *maskingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return slog.Default().Handler().Enabled(ctx, level)
+}
+
+func (h *maskingHandler) Handle(ctx context.Context, r slog.Record) error {
+	masked := slog.NewRecord(r.Time, r.Level, maskSecrets(r.Message), r.PC)
+	r.Attrs(func(attr slog.Attr) bool {
+		masked.AddAttrs(maskAttr(attr))
+		return true
+	})
+	return h.next().Handle(ctx, masked)
+}
+
+func (h *maskingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	masked := make([]slog.Attr, 0, len(attrs))
+	for _, attr := range attrs {
+		masked = append(masked, maskAttr(attr))
+	}
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithAttrs(masked) })
+}
+
+func (h *maskingHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithGroup(name) })
+}
+
+func maskAttr(attr slog.Attr) slog.Attr {
+	value := attr.Value.Resolve()
+	switch value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, maskSecrets(value.String()))
+	case slog.KindGroup:
+		attrs := value.Group()
+		masked := make([]any, 0, len(attrs))
+		for _, a := range attrs {
+			masked = append(masked, maskAttr(a))
+		}
+		return slog.Group(attr.Key, masked...)
+	case slog.KindAny:
+		if s := fmt.Sprintf("%+v", value.Any()); maskSecrets(s) != s {
+			return slog.String(attr.Key, maskSecrets(s))
+		}
+	}
+	return attr
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveSecrets(t *testing.T) {
+	t.Setenv(envSecretEnvPrefix, "GOTIFY_WEBHOOK_")
+	t.Setenv("GOTIFY_WEBHOOK_TOKEN", "client-s3cret")
+	t.Setenv("GOTIFY_WEBHOOK_PORT", "8080")
+	dir := t.TempDir()
+	t.Setenv(envSecretDir, dir)
+	file := filepath.Join(dir, "bot")
+	assert.NoError(t, os.WriteFile(file, []byte("bot-s3cret\n"), 0o600))
+
+	config := &Config{
+		ClientToken: "${env:GOTIFY_WEBHOOK_TOKEN}",
+		HostServer:  "ws://localhost:${env:GOTIFY_WEBHOOK_PORT}",
+		WebHooks: []*WebHook{{
+			Url:    "https://api.telegram.org/bot${file:" + file + "}/sendMessage",
+			Header: map[string]string{"Authorization": "Bearer ${env:GOTIFY_WEBHOOK_TOKEN}"},
+			Body:   `{"text": "$${not a reference}"}`,
+		}},
+	}
+
+	p := &MultiNotifierPlugin{}
+	t.Cleanup(func() { registerSecrets(p, nil) })
+	assert.NoError(t, p.ValidateAndSetConfig(config))
+	assert.Equal(t, "client-s3cret", p.config.ClientToken)
+	assert.Equal(t, "ws://localhost:8080", p.config.HostServer)
+	assert.Equal(t, "https://api.telegram.org/botbot-s3cret/sendMessage", p.config.WebHooks[0].Url)
+	assert.Equal(t, "Bearer client-s3cret", p.config.WebHooks[0].Header["Authorization"])
+	assert.Equal(t, `{"text": "${not a reference}"}`, p.config.WebHooks[0].Body)
+
+	// The saved config keeps the references.
+	assert.Equal(t, "${env:GOTIFY_WEBHOOK_TOKEN}", config.ClientToken)
+
+	// Secrets are masked in logs while the plugin is enabled.
+	assert.Equal(t, "client-s3cret", maskSecrets("client-s3cret"))
+	assert.NoError(t, p.Enable())
+	assert.Equal(t, "token ****** of ******", maskSecrets("token client-s3cret of bot-s3cret"))
+
+	// Invalid configs keep the secrets of the active one, reloads replace them.
+	assert.Error(t, p.ValidateAndSetConfig(&Config{ClientToken: "${file:bot}", HostServer: "ws://localhost", HistorySize: -1}))
+	assert.Equal(t, "******", maskSecrets("client-s3cret"))
+	assert.NoError(t, p.ValidateAndSetConfig(&Config{ClientToken: "plain", HostServer: "ws://localhost"}))
+	assert.Equal(t, "client-s3cret", maskSecrets("client-s3cret"))
+	assert.NoError(t, p.ValidateAndSetConfig(&Config{ClientToken: "${file:bot}", HostServer: "ws://localhost"}))
+	assert.Equal(t, "client-s3cret ******", maskSecrets("client-s3cret bot-s3cret"))
+
+	// Disabling the plugin releases its secrets, the config keeps them.
+	assert.NoError(t, p.Disable())
+	assert.Equal(t, "bot-s3cret", maskSecrets("bot-s3cret"))
+	assert.Equal(t, []string{"bot-s3cret"}, p.config.secrets)
+}
+
+func TestSecretPolicy(t *testing.T) {
+	t.Setenv("GOTIFY_DATABASE_CONNECTION", "db-s3cret")
+	t.Setenv("GOTIFY_WEBHOOK_TOKEN", "client-s3cret")
+	outside := filepath.Join(t.TempDir(), "config.yml")
+	assert.NoError(t, os.WriteFile(outside, []byte("db-s3cret"), 0o600))
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "token"), []byte("s3cret"), 0o600))
+	assert.NoError(t, os.Symlink(outside, filepath.Join(dir, "link")))
+
+	// Without the admin's permission no references are resolved.
+	_, err := lookupSecret("env", "GOTIFY_WEBHOOK_TOKEN")
+	assert.ErrorContains(t, err, envSecretEnvPrefix)
+	_, err = lookupSecret("file", filepath.Join(dir, "token"))
+	assert.ErrorContains(t, err, envSecretDir)
+
+	t.Setenv(envSecretEnvPrefix, "GOTIFY_WEBHOOK_")
+	t.Setenv(envSecretDir, dir)
+	value, err := lookupSecret("env", "GOTIFY_WEBHOOK_TOKEN")
+	assert.NoError(t, err)
+	assert.Equal(t, "client-s3cret", value)
+	_, err = lookupSecret("env", "GOTIFY_DATABASE_CONNECTION")
+	assert.Error(t, err)
+
+	for _, name := range []string{"token", filepath.Join(dir, "token"), filepath.Join(dir, "sub", "..", "token")} {
+		value, err := lookupSecret("file", name)
+		assert.NoError(t, err, name)
+		assert.Equal(t, "s3cret", value, name)
+	}
+	for _, name := range []string{outside, "../" + filepath.Base(filepath.Dir(outside)) + "/config.yml", filepath.Join(dir, "..", "x"), "link"} {
+		_, err := lookupSecret("file", name)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestResolveMissingSecrets(t *testing.T) {
+	t.Setenv(envSecretEnvPrefix, "GOTIFY_WEBHOOK_")
+	t.Setenv(envSecretDir, t.TempDir())
+	p := &MultiNotifierPlugin{}
+	err := p.ValidateAndSetConfig(&Config{ClientToken: "${env:GOTIFY_WEBHOOK_MISSING}", HostServer: "ws://localhost"})
+	assert.ErrorContains(t, err, "GOTIFY_WEBHOOK_MISSING")
+
+	err = p.ValidateAndSetConfig(&Config{ClientToken: "${file:nonexistent/token}", HostServer: "ws://localhost"})
+	assert.ErrorContains(t, err, "nonexistent/token")
+}
+
+func TestLoggerMasksSecrets(t *testing.T) {
+	var buf bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	defer slog.SetDefault(defaultLogger)
+
+	p := &MultiNotifierPlugin{}
+	registerSecrets(p, []string{"s3cret"})
+	defer registerSecrets(p, nil)
+
+	logger.With(slog.String("token", "s3cret")).Info("Sending to http://x/s3cret",
+		slog.String("url", "http://x/s3cret"),
+		slog.Any("err", errors.New("failed: s3cret")),
+		slog.Group("webhook", slog.String("url", "http://x/s3cret")),
+		slog.Int("count", 1))
+
+	assert.NotContains(t, buf.String(), "s3cret")
+	assert.Contains(t, buf.String(), "http://x/******")
+	assert.Contains(t, buf.String(), "count=1")
+}
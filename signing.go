@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Signing schemes of outbound requests.
+const (
+	SigningHMAC     = "hmac"
+	SigningStandard = "standard"
+)
+
+// Signing signs the requests of a webhook so receivers can verify they came from this plugin.
+//
+// The `hmac` scheme puts an HMAC of the body into Header. With Timestamp the unix time is sent in
+// TimestampHeader as well and the signed content is `<timestamp>.<body>`. The `standard` scheme
+// follows Standard Webhooks (https://www.standardwebhooks.com) with the headers `webhook-id`,
+// `webhook-timestamp` and `webhook-signature`.
+type Signing struct {
+	Scheme string `yaml:"scheme"`
+	// Secret is the HMAC key, `whsec_` prefixed secrets of the standard scheme are base64 decoded.
+	Secret          string `yaml:"secret"`
+	Algorithm       string `yaml:"algorithm"`
+	Header          string `yaml:"header"`
+	Encoding        string `yaml:"encoding"`
+	Prefix          string `yaml:"prefix"`
+	Timestamp       bool   `yaml:"timestamp"`
+	TimestampHeader string `yaml:"timestamp_header"`
+
+	key  []byte
+	hash func() hash.Hash
+}
+
+func (s *Signing) compile() (err error) {
+	if s == nil {
+		return nil
+	}
+	if s.Secret == "" {
+		return fmt.Errorf("secret is required")
+	}
+
+	switch s.Scheme {
+	case "", SigningHMAC:
+		s.Scheme = SigningHMAC
+		s.key = []byte(s.Secret)
+		if s.hash, err = hmacHash(s.Algorithm); err != nil {
+			return err
+		}
+		if s.Header == "" {
+			s.Header = "X-Signature"
+		}
+		if s.TimestampHeader == "" {
+			s.TimestampHeader = "X-Timestamp"
+		}
+		switch s.Encoding {
+		case "":
+			s.Encoding = "hex"
+		case "hex", "base64":
+		default:
+			return fmt.Errorf("unknown encoding %q", s.Encoding)
+		}
+	case SigningStandard:
+		s.key = []byte(s.Secret)
+		if strings.HasPrefix(s.Secret, "whsec_") {
+			if s.key, err = base64.StdEncoding.DecodeString(strings.TrimPrefix(s.Secret, "whsec_")); err != nil {
+				return fmt.Errorf("invalid secret: %w", err)
+			}
+		}
+		s.hash = sha256.New
+	default:
+		return fmt.Errorf("unknown scheme %q", s.Scheme)
+	}
+	return nil
+}
+
+// newWebhookID returns a random ID of a delivery. All attempts of a delivery share it, so
+// receivers can recognize retries as duplicates.
+func newWebhookID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate webhook id: %w", err)
+	}
+	return "msg_" + hex.EncodeToString(id), nil
+}
+
+// sign adds the signature headers of the body to the request, webhookID is the ID of the delivery.
+func (s *Signing) sign(req *http.Request, webhookID string, body string, now time.Time) error {
+	if s == nil {
+		return nil
+	}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	mac := hmac.New(s.hash, s.key)
+
+	if s.Scheme == SigningStandard {
+		mac.Write([]byte(webhookID + "." + timestamp + "." + body))
+		req.Header.Set("webhook-id", webhookID)
+		req.Header.Set("webhook-timestamp", timestamp)
+		req.Header.Set("webhook-signature", "v1,"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+		return nil
+	}
+
+	if s.Timestamp {
+		mac.Write([]byte(timestamp + "."))
+		req.Header.Set(s.TimestampHeader, timestamp)
+	}
+	mac.Write([]byte(body))
+	var signature string
+	if s.Encoding == "base64" {
+		signature = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	} else {
+		signature = hex.EncodeToString(mac.Sum(nil))
+	}
+	req.Header.Set(s.Header, s.Prefix+signature)
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSigningCompile(t *testing.T) {
+	assert.Error(t, (&Signing{}).compile())
+	assert.Error(t, (&Signing{Secret: "s", Scheme: "rsa"}).compile())
+	assert.Error(t, (&Signing{Secret: "s", Algorithm: "md5"}).compile())
+	assert.Error(t, (&Signing{Secret: "s", Encoding: "base32"}).compile())
+	assert.Error(t, (&Signing{Secret: "whsec_!!", Scheme: "standard"}).compile())
+
+	signing := &Signing{Secret: "s"}
+	assert.NoError(t, signing.compile())
+	assert.Equal(t, SigningHMAC, signing.Scheme)
+	assert.Equal(t, "X-Signature", signing.Header)
+}
+
+func TestSigningSign(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := `{"text": "hello"}`
+
+	signing := &Signing{Secret: "hush", Algorithm: "sha512", Encoding: "base64", Prefix: "sha512=", Timestamp: true}
+	assert.NoError(t, signing.compile())
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	assert.NoError(t, signing.sign(req, "msg_1", body, now))
+	mac := hmac.New(sha512.New, []byte("hush"))
+	mac.Write([]byte("1700000000." + body))
+	assert.Equal(t, "1700000000", req.Header.Get("X-Timestamp"))
+	assert.Equal(t, "sha512="+base64.StdEncoding.EncodeToString(mac.Sum(nil)), req.Header.Get("X-Signature"))
+
+	key := []byte("standard webhooks key")
+	signing = &Signing{Scheme: "standard", Secret: "whsec_" + base64.StdEncoding.EncodeToString(key)}
+	assert.NoError(t, signing.compile())
+	req = httptest.NewRequest(http.MethodPost, "/", nil)
+	id, err := newWebhookID()
+	assert.NoError(t, err)
+	assert.Regexp(t, `^msg_[0-9a-f]{32}$`, id)
+	assert.NoError(t, signing.sign(req, id, body, now))
+	assert.Equal(t, id, req.Header.Get("webhook-id"))
+	assert.Equal(t, "1700000000", req.Header.Get("webhook-timestamp"))
+	mac = hmac.New(sha256.New, key)
+	mac.Write([]byte(id + ".1700000000." + body))
+	assert.Equal(t, "v1,"+base64.StdEncoding.EncodeToString(mac.Sum(nil)), req.Header.Get("webhook-signature"))
+}
+
+func TestSendSignedRequest(t *testing.T) {
+	signatures := make(chan bool, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, []byte("hush"))
+		mac.Write(body)
+		signatures <- r.Header.Get("X-Hub-Signature-256") == "sha256="+hex.EncodeToString(mac.Sum(nil))
+	}))
+	defer server.Close()
+
+	webhook := &WebHook{Url: server.URL, Method: "POST", Signing: &Signing{Secret: "hush", Header: "X-Hub-Signature-256", Prefix: "sha256="}}
+	assert.NoError(t, webhook.Signing.compile())
+
+	p := &MultiNotifierPlugin{}
+	assert.NoError(t, p.sendHTTPRequest(context.Background(), webhook, `{"text": "hello"}`, "application/json"))
+	assert.True(t, <-signatures)
+}
+
+func TestRetriesKeepWebhookID(t *testing.T) {
+	var ids []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ids = append(ids, r.Header.Get("webhook-id"))
+		if len(ids) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	webhook := &WebHook{
+		Url:     server.URL,
+		Method:  "POST",
+		Signing: &Signing{Scheme: "standard", Secret: "hush"},
+		Retry:   &Retry{Attempts: 2, Backoff: Duration(time.Millisecond)},
+	}
+	assert.NoError(t, webhook.Signing.compile())
+	assert.NoError(t, webhook.Retry.compile())
+
+	p := &MultiNotifierPlugin{}
+	assert.NoError(t, p.sendHTTPRequest(context.Background(), webhook, "a", ""))
+	assert.NoError(t, p.sendHTTPRequest(context.Background(), webhook, "b", ""))
+	if assert.Len(t, ids, 3) {
+		assert.Equal(t, ids[0], ids[1])
+		assert.NotEqual(t, ids[1], ids[2])
+		history := p.stats.deliveries(&historyQuery{})
+		assert.Equal(t, ids[2], history[0].ID)
+	}
+}
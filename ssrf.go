@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Environment variables of the Gotify server configuring the destination policy. They are set by
+// the server admin, unlike the plugin config which every user can edit.
+const (
+	envDestinationProtection = "GOTIFY_WEBHOOK_DESTINATION_PROTECTION"
+	envAllowedDestinations   = "GOTIFY_WEBHOOK_ALLOWED_DESTINATIONS"
+)
+
+// blockedNetworks are the address ranges outbound requests may not connect to by default.
+var blockedNetworks = parseNetworks(
+	"0.0.0.0/8",      // "this" network
+	"10.0.0.0/8",     // private
+	"100.64.0.0/10",  // carrier-grade NAT
+	"127.0.0.0/8",    // loopback
+	"169.254.0.0/16", // link-local, cloud metadata endpoints
+	"172.16.0.0/12",  // private
+	"192.168.0.0/16", // private
+	"224.0.0.0/4",    // multicast
+	"::/128",         // unspecified
+	"::1/128",        // loopback
+	"64:ff9b:1::/48", // local-use NAT64
+	"fc00::/7",       // unique local
+	"fe80::/10",      // link-local
+	"ff00::/8",       // multicast
+)
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// DestinationPolicy decides which hosts webhook requests may be sent to.
+type DestinationPolicy struct {
+	// Disabled allows all destinations.
+	Disabled bool
+	// Hosts are allowed host names, `*.example.com` allows all subdomains.
+	Hosts []string
+	// Networks are allowed address ranges, even if they are blocked by default.
+	Networks []*net.IPNet
+}
+
+// loadDestinationPolicy reads the policy from the environment, if it is invalid the default policy
+// is returned with the error.
+func loadDestinationPolicy() (*DestinationPolicy, error) {
+	policy := &DestinationPolicy{}
+	switch strings.ToLower(os.Getenv(envDestinationProtection)) {
+	case "", "true", "on", "1":
+	case "false", "off", "0":
+		policy.Disabled = true
+	default:
+		return &DestinationPolicy{}, fmt.Errorf("invalid %s", envDestinationProtection)
+	}
+
+	for _, entry := range strings.Split(os.Getenv(envAllowedDestinations), ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case entry == "":
+		case strings.Contains(entry, "/"):
+			_, network, err := net.ParseCIDR(entry)
+			if err != nil {
+				return &DestinationPolicy{}, fmt.Errorf("invalid %s entry %q", envAllowedDestinations, entry)
+			}
+			policy.Networks = append(policy.Networks, network)
+		case net.ParseIP(entry) != nil:
+			ip := net.ParseIP(entry)
+			bits := 8 * len(ip)
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			policy.Networks = append(policy.Networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		default:
+			policy.Hosts = append(policy.Hosts, entry)
+		}
+	}
+	return policy, nil
+}
+
+// hostAllowed reports whether the host name is explicitly allowed.
+func (p *DestinationPolicy) hostAllowed(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, allowed := range p.Hosts {
+		if host == allowed || strings.HasPrefix(allowed, "*.") && strings.HasSuffix(host, allowed[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// nat64Network is the well-known NAT64 prefix, its addresses end with the IPv4 address they reach.
+var nat64Network = parseNetworks("64:ff9b::/96")[0]
+
+// ipAllowed reports whether connections to the address are allowed.
+func (p *DestinationPolicy) ipAllowed(ip net.IP) bool {
+	for _, network := range p.Networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	// NAT64 addresses are allowed if the IPv4 address they translate to is.
+	if ip.To4() == nil && nat64Network.Contains(ip) {
+		return p.ipAllowed(net.IPv4(ip[12], ip[13], ip[14], ip[15]))
+	}
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkURL rejects URLs whose host is blocked without resolving it, to report obviously blocked
+// webhooks when the config is saved.
+func (p *DestinationPolicy) checkURL(u *url.URL) error {
+	host := u.Hostname()
+	if p.Disabled || p.hostAllowed(host) {
+		return nil
+	}
+	if strings.EqualFold(host, "localhost") || strings.HasSuffix(strings.ToLower(host), ".localhost") {
+		return fmt.Errorf("destination %s is blocked, ask your admin to allow it with %s", host, envAllowedDestinations)
+	}
+	if ip := net.ParseIP(host); ip != nil && !p.ipAllowed(ip) {
+		return fmt.Errorf("destination %s is blocked, ask your admin to allow it with %s", host, envAllowedDestinations)
+	}
+	return nil
+}
+
+// dialContext connects to the address after checking all addresses it resolves to. The checked
+// address is dialed, so a second DNS lookup can't return a different one.
+func (p *DestinationPolicy) dialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		if p.hostAllowed(host) {
+			return dialer.DialContext(ctx, network, addr)
+		}
+
+		ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		var lastErr error
+		for _, ip := range ips {
+			if !p.ipAllowed(ip.IP) {
+				return nil, fmt.Errorf("destination %s (%s) is blocked, ask your admin to allow it with %s", host, ip.IP, envAllowedDestinations)
+			}
+		}
+		for _, ip := range ips {
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.IP.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+		}
+		if lastErr == nil {
+			lastErr = fmt.Errorf("no address found for %s", host)
+		}
+		return nil, lastErr
+	}
+}
+
+// checkDestination validates an absolute URL of the config against the destination policy.
+func checkDestination(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid URL: %s", raw)
+	}
+	return destinationPolicy.checkURL(u)
+}
+
+// newWebhookClient returns the HTTP client of outbound webhook requests enforcing the policy on
+// every connection, including the ones of redirects. Environment proxies are only used if the
+// policy is disabled, since the destination couldn't be checked otherwise.
+func newWebhookClient(policy *DestinationPolicy) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if !policy.Disabled {
+		transport.Proxy = nil
+		transport.DialContext = policy.dialContext(&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second})
+	}
+	return &http.Client{Transport: transport, Timeout: webhookTimeout}
+}
+
+// webhookTimeout limits outbound requests including reading the response, so a hung endpoint
+// can't block its deliveries forever.
+const webhookTimeout = 30 * time.Second
+
+var (
+	// destinationPolicyErr is reported when the config is validated, until then the default policy
+	// applies.
+	destinationPolicy, destinationPolicyErr = loadDestinationPolicy()
+	// webhookClient sends all requests to webhook, file and token URLs of the config.
+	webhookClient = newWebhookClient(destinationPolicy)
+)
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	// Tests send webhooks to local servers and mock http.DefaultTransport.
+	destinationPolicy = &DestinationPolicy{Disabled: true}
+	webhookClient = &http.Client{}
+	os.Exit(m.Run())
+}
+
+func TestLoadDestinationPolicy(t *testing.T) {
+	t.Setenv(envAllowedDestinations, "hooks.internal, *.corp.example, 10.1.0.0/16, 192.168.1.10")
+	policy, err := loadDestinationPolicy()
+	assert.NoError(t, err)
+	assert.False(t, policy.Disabled)
+	assert.True(t, policy.hostAllowed("hooks.internal"))
+	assert.True(t, policy.hostAllowed("chat.corp.example"))
+	assert.False(t, policy.hostAllowed("corp.example.org"))
+	assert.True(t, policy.ipAllowed(net.ParseIP("10.1.2.3")))
+	assert.True(t, policy.ipAllowed(net.ParseIP("192.168.1.10")))
+	assert.False(t, policy.ipAllowed(net.ParseIP("192.168.1.11")))
+
+	t.Setenv(envAllowedDestinations, "10.0.0.0/33")
+	_, err = loadDestinationPolicy()
+	assert.Error(t, err)
+
+	t.Setenv(envAllowedDestinations, "")
+	t.Setenv(envDestinationProtection, "off")
+	policy, err = loadDestinationPolicy()
+	assert.NoError(t, err)
+	assert.True(t, policy.Disabled)
+}
+
+func TestDestinationPolicyBlocksInternalAddresses(t *testing.T) {
+	policy := &DestinationPolicy{}
+	for _, ip := range []string{"127.0.0.1", "10.0.0.1", "172.16.5.4", "192.168.0.1", "169.254.169.254", "::1", "fe80::1", "fd00::1", "::ffff:127.0.0.1", "0.0.0.0", "64:ff9b::a9fe:a9fe", "64:ff9b::127.0.0.1", "64:ff9b:1::1"} {
+		assert.False(t, policy.ipAllowed(net.ParseIP(ip)), ip)
+	}
+	for _, ip := range []string{"1.1.1.1", "2606:4700:4700::1111", "64:ff9b::1.1.1.1"} {
+		assert.True(t, policy.ipAllowed(net.ParseIP(ip)), ip)
+	}
+
+	for _, u := range []string{"http://localhost:8080", "http://169.254.169.254/latest/meta-data", "http://[::1]/"} {
+		parsed, _ := url.Parse(u)
+		assert.Error(t, policy.checkURL(parsed), u)
+	}
+	parsed, _ := url.Parse("https://example.com/hook")
+	assert.NoError(t, policy.checkURL(parsed))
+}
+
+func TestWebhookClientEnforcesPolicy(t *testing.T) {
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer internal.Close()
+	// The redirecting server is allowed, the internal one it redirects to is not.
+	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://localhost:"+internalPort(internal)+"/", http.StatusFound)
+	}))
+	defer redirect.Close()
+
+	send := func(client *http.Client, u string) error {
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, u, nil)
+		res, err := client.Do(req)
+		if err == nil {
+			res.Body.Close()
+		}
+		return err
+	}
+
+	client := newWebhookClient(&DestinationPolicy{})
+	assert.ErrorContains(t, send(client, internal.URL), "is blocked")
+
+	client = newWebhookClient(&DestinationPolicy{Hosts: []string{"127.0.0.1"}})
+	assert.NoError(t, send(client, internal.URL))
+	assert.ErrorContains(t, send(client, redirect.URL), "is blocked")
+
+	client = newWebhookClient(&DestinationPolicy{Networks: parseNetworks("127.0.0.0/8", "::1/128")})
+	assert.NoError(t, send(client, redirect.URL))
+}
+
+func internalPort(server *httptest.Server) string {
+	u, _ := url.Parse(server.URL)
+	return u.Port()
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// statsFlushInterval is how often changed statistics are persisted.
+	statsFlushInterval = 5 * time.Second
+	// recentDeliveries is the number of deliveries shown on the status page.
+	recentDeliveries = 10
+)
+
+// Outcomes of deliveries.
+const (
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookStats are the delivery statistics of a webhook.
+type WebhookStats struct {
+	// Sent counts deliveries, Succeeded and Failed their outcome and Retried the repeated attempts.
+	Sent      int64 `json:"sent"`
+	Succeeded int64 `json:"succeeded"`
+	Failed    int64 `json:"failed"`
+	Retried   int64 `json:"retried"`
+	// Filtered counts messages not matching the webhook's apps, filter or when expression.
+	Filtered int64 `json:"filtered"`
+	// Throttled counts messages suppressed as duplicates or dropped outside of the schedule.
+	Throttled   int64     `json:"throttled"`
+	LastSuccess time.Time `json:"last_success"`
+	LastFailure time.Time `json:"last_failure"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// DeliveryRecord is a delivery to a webhook, StatusCode and Response are the ones of the last
+// attempt.
+type DeliveryRecord struct {
+	// ID is the ID of the delivery, it is sent as webhook-id with standard webhook signatures.
+	ID         string    `json:"id,omitempty"`
+	Time       time.Time `json:"time"`
+	Webhook    string    `json:"webhook"`
+	MessageIDs []uint    `json:"message_ids,omitempty"`
+	AppIDs     []uint    `json:"app_ids,omitempty"`
+	Attempts   int       `json:"attempts"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Response   string    `json:"response,omitempty"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	// DurationMS is the duration of the delivery including retries in milliseconds.
+	DurationMS int64 `json:"duration_ms"`
+}
+
+// deliveryStats collects the statistics of all webhooks in memory until they are persisted.
+type deliveryStats struct {
+	mu       sync.Mutex
+	messages int
+	webhooks map[string]*WebhookStats
+	dirty    bool
+	// history holds the last historySize deliveries, the latest last.
+	history     []*DeliveryRecord
+	historySize int
+	// latency is the histogram of delivery durations by webhook, it isn't persisted.
+	latency map[string]*histogram
+}
+
+func (s *deliveryStats) countMessage() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages++
+	s.dirty = true
+}
+
+// update applies fn to the statistics of the webhook.
+func (s *deliveryStats) update(webhook *WebHook, fn func(stats *WebhookStats)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.webhooks == nil {
+		s.webhooks = make(map[string]*WebhookStats)
+	}
+	key := webhookKey(webhook)
+	stats, ok := s.webhooks[key]
+	if !ok {
+		stats = &WebhookStats{}
+		s.webhooks[key] = stats
+	}
+	fn(stats)
+	s.dirty = true
+}
+
+func (s *deliveryStats) succeeded(webhook *WebHook) {
+	s.update(webhook, func(stats *WebhookStats) {
+		stats.Succeeded++
+		stats.LastSuccess = time.Now()
+	})
+}
+
+func (s *deliveryStats) failed(webhook *WebHook, err error) {
+	s.update(webhook, func(stats *WebhookStats) {
+		stats.Failed++
+		stats.LastFailure = time.Now()
+		stats.LastError = maskSecrets(err.Error())
+	})
+}
+
+// delivered completes the record of a delivery started at record.Time, err is nil if it succeeded.
+func (s *deliveryStats) delivered(webhook *WebHook, record *DeliveryRecord, err error) {
+	record.Webhook = webhookKey(webhook)
+	record.Status = DeliverySucceeded
+	duration := time.Since(record.Time)
+	record.DurationMS = duration.Milliseconds()
+	if err != nil {
+		record.Status, record.Error = DeliveryFailed, maskSecrets(err.Error())
+		s.failed(webhook, err)
+	} else {
+		s.succeeded(webhook)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.latency == nil {
+		s.latency = make(map[string]*histogram)
+	}
+	h, ok := s.latency[record.Webhook]
+	if !ok {
+		h = &histogram{}
+		s.latency[record.Webhook] = h
+	}
+	h.observe(duration.Seconds())
+
+	s.history = append(s.history, record)
+	s.trimHistory()
+	s.dirty = true
+}
+
+// setHistorySize limits the number of deliveries kept in the history.
+func (s *deliveryStats) setHistorySize(size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.historySize = size
+	s.trimHistory()
+}
+
+func (s *deliveryStats) trimHistory() {
+	size := s.historySize
+	if size == 0 {
+		size = defaultHistorySize
+	}
+	if len(s.history) > size {
+		s.history = append([]*DeliveryRecord(nil), s.history[len(s.history)-size:]...)
+	}
+}
+
+// lastDeliveries returns the last deliveries for the status page, the latest first.
+func (s *deliveryStats) lastDeliveries() []*DeliveryRecord {
+	return s.deliveries(&historyQuery{limit: recentDeliveries})
+}
+
+// latencies returns a copy of the latency histograms.
+func (s *deliveryStats) latencies() map[string]*histogram {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	histograms := make(map[string]*histogram, len(s.latency))
+	for key, h := range s.latency {
+		h := *h
+		h.counts = append([]uint64(nil), h.counts...)
+		histograms[key] = &h
+	}
+	return histograms
+}
+
+// snapshot returns a copy of the statistics.
+func (s *deliveryStats) snapshot() (int, map[string]*WebhookStats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	webhooks := make(map[string]*WebhookStats, len(s.webhooks))
+	for key, stats := range s.webhooks {
+		stats := *stats
+		webhooks[key] = &stats
+	}
+	return s.messages, webhooks
+}
+
+// startStats restores the persisted statistics and persists changes until ctx is done.
+func (p *MultiNotifierPlugin) startStats(ctx context.Context) {
+	storage, err := p.loadStorage()
+	if err != nil {
+		logger.Error("Failed to restore statistics", slog.Any("err", err))
+		storage = &Storage{}
+	}
+
+	p.stats.mu.Lock()
+	p.stats.messages = storage.CalledTimes
+	p.stats.webhooks = storage.Stats
+	p.stats.history = storage.History
+	p.stats.trimHistory()
+	p.stats.dirty = false
+	p.stats.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(statsFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				p.flushStats()
+				return
+			case <-ticker.C:
+				p.flushStats()
+			}
+		}
+	}()
+}
+
+// flushStats persists the statistics if they changed.
+func (p *MultiNotifierPlugin) flushStats() {
+	p.stats.mu.Lock()
+	dirty := p.stats.dirty
+	p.stats.dirty = false
+	p.stats.mu.Unlock()
+	if !dirty {
+		return
+	}
+
+	messages, webhooks := p.stats.snapshot()
+	history := p.stats.historySnapshot()
+	err := p.updateStorage(func(storage *Storage) {
+		storage.CalledTimes = messages
+		storage.Stats = webhooks
+		storage.History = history
+	})
+	if err != nil {
+		logger.Error("Failed to persist statistics", slog.Any("err", err))
+	}
+}
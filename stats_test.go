@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetry(t *testing.T) {
+	assert.Error(t, (&Retry{}).compile())
+	assert.Error(t, (&Retry{Attempts: 2, Backoff: -1}).compile())
+
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&calls, 1) {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 4:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	webhook := &WebHook{Name: "api", Url: server.URL, Method: "POST", Retry: &Retry{Attempts: 3, Backoff: Duration(time.Millisecond)}}
+	assert.NoError(t, webhook.Retry.compile())
+	p := &MultiNotifierPlugin{}
+
+	assert.NoError(t, p.sendHTTPRequest(context.Background(), webhook, "a", ""))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	// Client errors aren't retried.
+	assert.EqualError(t, p.sendHTTPRequest(context.Background(), webhook, "b", ""), "unexpected status code: 400")
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls))
+
+	_, webhooks := p.stats.snapshot()
+	stats := webhooks["api"]
+	assert.Equal(t, int64(2), stats.Sent)
+	assert.Equal(t, int64(2), stats.Retried)
+	assert.Equal(t, int64(1), stats.Succeeded)
+	assert.Equal(t, int64(1), stats.Failed)
+	assert.Equal(t, "unexpected status code: 400", stats.LastError)
+	assert.False(t, stats.LastSuccess.IsZero())
+	assert.False(t, stats.LastFailure.IsZero())
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, 2*time.Second, parseRetryAfter("2", now))
+	assert.Equal(t, time.Minute, parseRetryAfter("Mon, 01 Jan 2024 12:01:00 GMT", now))
+	assert.Zero(t, parseRetryAfter("", now))
+	assert.Zero(t, parseRetryAfter("-1", now))
+	assert.Zero(t, parseRetryAfter("soon", now))
+
+	var (
+		calls int32
+		first time.Time
+		retry time.Time
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&calls, 1) {
+		case 1:
+			first = time.Now()
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			retry = time.Now()
+		case 3:
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	webhook := &WebHook{Url: server.URL, Method: "POST", Retry: &Retry{Attempts: 2, Backoff: Duration(time.Millisecond)}}
+	assert.NoError(t, webhook.Retry.compile())
+	p := &MultiNotifierPlugin{}
+	assert.NoError(t, p.sendHTTPRequest(context.Background(), webhook, "a", ""))
+	assert.GreaterOrEqual(t, retry.Sub(first), time.Second)
+
+	// Delays beyond the limit aren't waited for.
+	assert.Error(t, p.sendHTTPRequest(context.Background(), webhook, "b", ""))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
+
+func TestDeliveryStats(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	storage := &memoryStorage{}
+	p := &MultiNotifierPlugin{}
+	p.SetStorageHandler(storage)
+	assert.NoError(t, p.ValidateAndSetConfig(&Config{
+		HostServer: "ws://localhost",
+		WebHooks: []*WebHook{
+			{Name: "all", Url: server.URL},
+			{Name: "ops", Url: server.URL, Apps: AppList{IDs: []uint{2}}, Dedup: &Dedup{Window: Duration(time.Hour)}},
+		},
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	p.startStats(ctx)
+
+	for _, msg := range []*MessageExternal{
+		{ApplicationID: 1, Title: "a"},
+		{ApplicationID: 2, Title: "b"},
+		{ApplicationID: 2, Title: "b"},
+	} {
+		assert.Empty(t, p.sendMessage(ctx, msg, p.config.WebHooks))
+	}
+
+	messages, webhooks := p.stats.snapshot()
+	assert.Equal(t, 3, messages)
+	assert.Equal(t, int64(3), webhooks["all"].Succeeded)
+	assert.Equal(t, int64(1), webhooks["ops"].Sent)
+	assert.Equal(t, int64(1), webhooks["ops"].Filtered)
+	assert.Equal(t, int64(1), webhooks["ops"].Throttled)
+
+	// The statistics are persisted when the plugin is disabled and restored when it is enabled.
+	cancel()
+	assert.Eventually(t, func() bool {
+		persisted, err := p.loadStorage()
+		return err == nil && persisted.CalledTimes == 3
+	}, time.Second, 10*time.Millisecond)
+	persisted, _ := p.loadStorage()
+	assert.Equal(t, int64(3), persisted.Stats["all"].Sent)
+
+	restored := &MultiNotifierPlugin{}
+	restored.SetStorageHandler(storage)
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	restored.startStats(ctx)
+	messages, webhooks = restored.stats.snapshot()
+	assert.Equal(t, 3, messages)
+	assert.Equal(t, int64(1), webhooks["ops"].Throttled)
+	for _, webhook := range p.config.WebHooks {
+		webhook.Dedup.stop()
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const statusTimeFormat = "2006-01-02 15:04:05"
+
+// streamStatus is the state of the connection to the Gotify message stream.
+type streamStatus struct {
+	mu        sync.Mutex
+	connected bool
+	since     time.Time
+	connects  int
+	lastError string
+}
+
+func (s *streamStatus) setConnected() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connected = true
+	s.since = time.Now()
+	s.connects++
+}
+
+func (s *streamStatus) setDisconnected(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.connected || s.since.IsZero() {
+		s.since = time.Now()
+	}
+	s.connected = false
+	if err != nil {
+		s.lastError = maskSecrets(err.Error())
+	}
+}
+
+// reconnects returns how often the stream was connected again after the first connection.
+func (s *streamStatus) reconnects() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.connects == 0 {
+		return 0
+	}
+	return s.connects - 1
+}
+
+// queueDepth returns the number of messages waiting to be delivered to the webhook.
+func queueDepth(webhook *WebHook) int {
+	depth := webhook.queue.size()
+	if webhook.Batch != nil {
+		depth += webhook.Batch.size()
+	}
+	if webhook.Schedule != nil {
+		depth += webhook.Schedule.size()
+	}
+	return depth
+}
+
+// displayURL returns the URL without credentials and secrets.
+func displayURL(raw string) string {
+	return maskSecrets(redactURL(raw))
+}
+
+// redactURL returns the URL without credentials.
+func redactURL(raw string) string {
+	if u, err := url.Parse(raw); err == nil {
+		return u.Redacted()
+	}
+	return raw
+}
+
+// markdownCell escapes a value for a markdown table cell.
+func markdownCell(s string) string {
+	s = strings.ReplaceAll(s, "|", `\|`)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func formatStatusTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Local().Format(statusTimeFormat)
+}
+
+// webhookRules summarizes which messages the webhook receives and how they are delivered.
+func webhookRules(webhook *WebHook) string {
+	var rules []string
+	if webhook.EscalationOnly {
+		rules = append(rules, "escalations only")
+	}
+	if len(webhook.Apps.IDs) > 0 {
+		rules = append(rules, fmt.Sprintf("apps %v", webhook.Apps.IDs))
+	}
+	if len(webhook.Apps.Names) > 0 {
+		rules = append(rules, fmt.Sprintf("app names %s", strings.Join(webhook.Apps.Names, ", ")))
+	}
+	if webhook.Filter != nil {
+		rules = append(rules, "filter")
+	}
+	if webhook.When != "" {
+		rules = append(rules, fmt.Sprintf("when `%s`", webhook.When))
+	}
+	if webhook.Schedule != nil {
+		rules = append(rules, "schedule ("+webhook.Schedule.OutOfWindow+")")
+	}
+	if webhook.Dedup != nil {
+		rules = append(rules, fmt.Sprintf("dedup %s", time.Duration(webhook.Dedup.Window)))
+	}
+	if webhook.Batch != nil {
+		rules = append(rules, "batch")
+	}
+	if len(rules) == 0 {
+		return "all messages"
+	}
+	return strings.Join(rules, ", ")
+}
+
+// statusMarkdown renders the live status of the plugin.
+func (p *MultiNotifierPlugin) statusMarkdown() string {
+	config := p.currentConfig()
+	var b strings.Builder
+
+	b.WriteString("## Status\n\n")
+	p.stream.mu.Lock()
+	switch {
+	case p.stream.connected:
+		fmt.Fprintf(&b, "**Stream:** connected since %s", formatStatusTime(p.stream.since))
+	case p.stream.since.IsZero():
+		b.WriteString("**Stream:** not connected, the plugin is disabled")
+	default:
+		fmt.Fprintf(&b, "**Stream:** disconnected since %s", formatStatusTime(p.stream.since))
+	}
+	lastStreamError := p.stream.lastError
+	p.stream.mu.Unlock()
+	fmt.Fprintf(&b, " (%d reconnects)\n\n", p.stream.reconnects())
+	if lastStreamError != "" {
+		fmt.Fprintf(&b, "**Last stream error:** %s\n\n", markdownCell(lastStreamError))
+	}
+
+	messages, stats := p.stats.snapshot()
+	fmt.Fprintf(&b, "**Messages received:** %d\n\n", messages)
+
+	b.WriteString("## Webhooks\n\n")
+	if len(config.WebHooks) == 0 {
+		b.WriteString("No webhooks configured.\n\n")
+	} else {
+		b.WriteString("| Webhook | URL | Rules | Sent | Succeeded | Failed | Retried | Filtered | Throttled | Queued | Last success | Last error |\n")
+		b.WriteString("| --- | --- | --- | --- | --- | --- | --- | --- | --- | --- | --- | --- |\n")
+		for _, webhook := range config.WebHooks {
+			s := stats[webhookKey(webhook)]
+			if s == nil {
+				s = &WebhookStats{}
+			}
+			lastError := "-"
+			if s.LastError != "" {
+				lastError = fmt.Sprintf("%s (%s)", s.LastError, formatStatusTime(s.LastFailure))
+			}
+			fmt.Fprintf(&b, "| %s | %s | %s | %d | %d | %d | %d | %d | %d | %d | %s | %s |\n",
+				markdownCell(webhook.Name), markdownCell(maskSecretList(redactURL(webhook.Url), config.secrets)), markdownCell(webhookRules(webhook)),
+				s.Sent, s.Succeeded, s.Failed, s.Retried, s.Filtered, s.Throttled, queueDepth(webhook),
+				formatStatusTime(s.LastSuccess), markdownCell(lastError))
+		}
+		b.WriteString("\n")
+	}
+
+	b.WriteString("## Recent deliveries\n\n")
+	deliveries := p.stats.lastDeliveries()
+	if len(deliveries) == 0 {
+		b.WriteString("No deliveries yet.\n")
+	} else {
+		b.WriteString("| Time | Webhook | Result | Duration | Error |\n")
+		b.WriteString("| --- | --- | --- | --- | --- |\n")
+		for _, d := range deliveries {
+			fmt.Fprintf(&b, "| %s | %s | %s | %s | %s |\n", formatStatusTime(d.Time), markdownCell(displayURL(d.Webhook)),
+				d.Status, time.Duration(d.DurationMS)*time.Millisecond, markdownCell(d.Error))
+		}
+	}
+
+	if names := inboundNames(config); len(names) > 0 {
+		b.WriteString("\n## Inbound routes\n\n")
+		for _, name := range names {
+			fmt.Fprintf(&b, "- `%s`: `%s/inbound/%s`\n", name, p.basePath, name)
+		}
+	}
+	return b.String()
+}
+
+func inboundNames(config *Config) []string {
+	names := make([]string, 0, len(config.Inbound))
+	for _, route := range config.Inbound {
+		names = append(names, route.Name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// loadStorage reads the persisted plugin state. An empty state is returned if nothing was
+// persisted yet or the plugin has no storage handler.
+func (p *MultiNotifierPlugin) loadStorage() (*Storage, error) {
+	storage := &Storage{}
+	if p.storageHandler == nil {
+		return storage, nil
+	}
+
+	data, err := p.storageHandler.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load storage: %w", err)
+	}
+	if len(data) == 0 {
+		return storage, nil
+	}
+
+	if err := json.Unmarshal(data, storage); err != nil {
+		return nil, fmt.Errorf("failed to decode storage: %w", err)
+	}
+	return storage, nil
+}
+
+// updateStorage applies fn to the persisted plugin state and saves it.
+func (p *MultiNotifierPlugin) updateStorage(fn func(storage *Storage)) error {
+	if p.storageHandler == nil {
+		return nil
+	}
+
+	p.storageMu.Lock()
+	defer p.storageMu.Unlock()
+
+	storage, err := p.loadStorage()
+	if err != nil {
+		return err
+	}
+
+	fn(storage)
+
+	data, err := json.Marshal(storage)
+	if err != nil {
+		return fmt.Errorf("failed to encode storage: %w", err)
+	}
+	if err := p.storageHandler.Save(data); err != nil {
+		return fmt.Errorf("failed to save storage: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryStorage is an in-memory plugin.StorageHandler.
+type memoryStorage struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+func (m *memoryStorage) Save(b []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data = append([]byte(nil), b...)
+	return nil
+}
+
+func (m *memoryStorage) Load() ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.data, nil
+}
+
+func TestUpdateStorage(t *testing.T) {
+	plugin := &MultiNotifierPlugin{}
+
+	// Without a storage handler nothing is persisted.
+	assert.NoError(t, plugin.updateStorage(func(storage *Storage) { storage.CalledTimes++ }))
+	storage, err := plugin.loadStorage()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, storage.CalledTimes)
+
+	plugin.SetStorageHandler(&memoryStorage{})
+	assert.NoError(t, plugin.updateStorage(func(storage *Storage) { storage.CalledTimes++ }))
+	assert.NoError(t, plugin.updateStorage(func(storage *Storage) { storage.CalledTimes++ }))
+	storage, err = plugin.loadStorage()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, storage.CalledTimes)
+}
+
+func TestWebhookKeys(t *testing.T) {
+	t.Setenv(envSecretEnvPrefix, "GOTIFY_WEBHOOK_")
+	t.Setenv("GOTIFY_WEBHOOK_BOT", "bot-s3cret")
+
+	url := "https://api.telegram.org/bot${env:GOTIFY_WEBHOOK_BOT}/sendMessage"
+	p := &MultiNotifierPlugin{}
+	t.Cleanup(func() { registerSecrets(p, nil) })
+	assert.NoError(t, p.ValidateAndSetConfig(&Config{
+		HostServer: "ws://localhost",
+		WebHooks:   []*WebHook{{Url: url}, {Url: url}, {Name: "chat", Url: url}},
+	}))
+
+	// Unnamed webhooks with the same URL are kept apart, without persisting the resolved URL.
+	webhooks := p.config.WebHooks
+	keys := []string{webhookKey(webhooks[0]), webhookKey(webhooks[1]), webhookKey(webhooks[2])}
+	assert.Equal(t, urlKey(url), keys[0])
+	assert.Equal(t, urlKey(url)+"-2", keys[1])
+	assert.Equal(t, "chat", keys[2])
+	for _, key := range keys {
+		assert.NotContains(t, key, "s3cret")
+	}
+}
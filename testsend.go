@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// webhookTest is the request body of the test route, without a message a sample message is used.
+type webhookTest struct {
+	Send    bool             `json:"send"`
+	Message *MessageExternal `json:"message"`
+}
+
+// webhookTestResult is the request rendered for the test and, if it was sent, its response.
+type webhookTestResult struct {
+	Method     string            `json:"method"`
+	URL        string            `json:"url"`
+	Headers    map[string]string `json:"headers"`
+	Body       string            `json:"body"`
+	Sent       bool              `json:"sent"`
+	StatusCode int               `json:"status_code,omitempty"`
+	Response   string            `json:"response,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// sampleMessage is the message sent by tests without a message of their own.
+func sampleMessage(webhook *WebHook) *MessageExternal {
+	msg := &MessageExternal{
+		Title:    "Test message",
+		Message:  "This is a test message of the Gotify webhook plugin.",
+		Priority: priorityWarning,
+		Date:     time.Now(),
+	}
+	if len(webhook.Apps.IDs) > 0 {
+		msg.ApplicationID = webhook.Apps.IDs[0]
+	}
+	return msg
+}
+
+// renderTest renders the body of the webhook for the message, batch webhooks render a digest of it.
+func (p *MultiNotifierPlugin) renderTest(ctx context.Context, webhook *WebHook, msg *MessageExternal) (string, string, error) {
+	if webhook.Batch != nil {
+		body, err := p.renderBatchBody(ctx, webhook, []*BatchEntry{{Message: msg}})
+		return body, "", err
+	}
+	return p.renderWebhookBody(ctx, webhook, msg)
+}
+
+// requestHeaders returns the headers of the request with secrets masked.
+func requestHeaders(req *http.Request) map[string]string {
+	headers := make(map[string]string, len(req.Header))
+	for k, v := range req.Header {
+		headers[k] = maskSecrets(strings.Join(v, ", "))
+	}
+	return headers
+}
+
+// logDryRun logs the request the webhook would have sent in dry-run mode.
+func (p *MultiNotifierPlugin) logDryRun(ctx context.Context, webhook *WebHook, body string, contentType string, relay *relayInfo) error {
+	id, err := newWebhookID()
+	if err != nil {
+		return err
+	}
+	req, err := newWebhookRequest(ctx, webhook, id, body, contentType, relay)
+	if err != nil {
+		return err
+	}
+	logger.Info("Dry run, not sending webhook request",
+		slog.String("webhook", webhookLabel(webhook)), slog.String("method", req.Method), slog.String("url", displayURL(webhook.Url)),
+		slog.Any("header", requestHeaders(req)), slog.String("body", body))
+	return nil
+}
+
+func (p *MultiNotifierPlugin) handleTestWebhook(c *gin.Context) {
+	config := p.currentConfig()
+	if config == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "plugin is not configured"})
+		return
+	}
+	if !authorizeToken(c, config.APIToken) {
+		return
+	}
+	webhook := findWebHook(config.WebHooks, c.Param("name"))
+	if webhook == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown webhook"})
+		return
+	}
+
+	test := &webhookTest{}
+	if err := json.NewDecoder(io.LimitReader(c.Request.Body, maxInboundBody)).Decode(test); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body: " + err.Error()})
+		return
+	}
+	msg := test.Message
+	if msg == nil {
+		msg = sampleMessage(webhook)
+	}
+	ctx := c.Request.Context()
+	if apps := p.currentApps(); apps != nil {
+		msg.app, _ = apps.get(ctx, msg.ApplicationID)
+	}
+	relayID, err := p.relayID()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	relay := nextRelay(relayID, []*MessageExternal{msg})
+	msg.relay = relay.String()
+
+	body, contentType, err := p.renderTest(ctx, webhook, msg)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": maskSecrets(err.Error())})
+		return
+	}
+	body = withRelayExtra(webhook, body, relay)
+	id, err := newWebhookID()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	req, err := newWebhookRequest(ctx, webhook, id, body, contentType, relay)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": maskSecrets(err.Error())})
+		return
+	}
+
+	result := &webhookTestResult{
+		Method:  req.Method,
+		URL:     displayURL(webhook.Url),
+		Headers: requestHeaders(req),
+		Body:    maskSecrets(body),
+	}
+	// Dry-run mode only renders, like for forwarded messages.
+	if test.Send && !config.DryRun {
+		record := &DeliveryRecord{}
+		err := sendWebhookRequest(webhook, req, record)
+		result.Sent = true
+		result.StatusCode, result.Response = record.StatusCode, record.Response
+		if err != nil {
+			result.Error = maskSecrets(err.Error())
+		}
+		logger.Info("Sent test message", slog.String("webhook", webhook.Name), slog.Int("status", record.StatusCode), slog.Any("err", err))
+	}
+	c.JSON(http.StatusOK, result)
+}
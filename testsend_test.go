@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleTestWebhook(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		bodies []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("queued"))
+	}))
+	defer server.Close()
+
+	p := &MultiNotifierPlugin{}
+	assert.NoError(t, p.ValidateAndSetConfig(&Config{
+		HostServer: "ws://localhost",
+		APIToken:   "secret",
+		WebHooks: []*WebHook{
+			{Name: "chat", Url: server.URL, BodyFormat: BodyFormatJSON, Body: `{"text": "{{.title}}: {{.message}}"}`, Header: map[string]string{"X-Team": "ops"}},
+			{Name: "broken", Url: server.URL, Body: `{{template "missing"}}`},
+		},
+	}))
+	router := newTestRouter(p)
+
+	test := func(name, body string) (int, *webhookTestResult) {
+		req := httptest.NewRequest(http.MethodPost, "/plugin/1/custom/xyz/webhooks/"+name+"/test", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		result := &webhookTestResult{}
+		json.Unmarshal(w.Body.Bytes(), result)
+		return w.Code, result
+	}
+
+	code, result := test("chat", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, http.MethodPost, result.Method)
+	assert.Equal(t, server.URL, result.URL)
+	assert.Equal(t, "application/json", result.Headers["Content-Type"])
+	assert.Equal(t, "ops", result.Headers["X-Team"])
+	assert.Equal(t, "1", result.Headers[relayHopsHeader])
+	assert.Equal(t, `{"text":"Test message: This is a test message of the Gotify webhook plugin."}`, result.Body)
+	assert.False(t, result.Sent)
+	assert.Empty(t, bodies)
+
+	code, result = test("chat", `{"send": true, "message": {"title": "Disk full", "message": "/var at 95%"}}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, result.Sent)
+	assert.Equal(t, http.StatusAccepted, result.StatusCode)
+	assert.Equal(t, "queued", result.Response)
+	assert.Equal(t, []string{`{"text":"Disk full: /var at 95%"}`}, bodies)
+
+	code, _ = test("broken", "")
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+	code, _ = test("unknown", "")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = test("chat", "{")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// In dry-run mode test messages aren't sent either.
+	p.config.DryRun = true
+	_, result = test("chat", `{"send": true}`)
+	assert.False(t, result.Sent)
+	assert.Len(t, bodies, 1)
+}
+
+func TestDryRun(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request sent in dry-run mode")
+	}))
+	defer server.Close()
+
+	p := &MultiNotifierPlugin{}
+	assert.NoError(t, p.ValidateAndSetConfig(&Config{
+		HostServer: "ws://localhost",
+		DryRun:     true,
+		WebHooks:   []*WebHook{{Name: "chat", Url: server.URL}},
+	}))
+	assert.Empty(t, p.sendMessage(context.Background(), &MessageExternal{ApplicationID: 1, Title: "a"}, p.config.WebHooks))
+	_, stats := p.stats.snapshot()
+	assert.Nil(t, stats["chat"])
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultServiceName = "gotify-webhook"
+	// traceFlushInterval is how often finished spans are exported.
+	traceFlushInterval = 5 * time.Second
+	// maxQueuedSpans limits the spans waiting for their export, further spans are dropped.
+	maxQueuedSpans     = 2048
+	traceExportTimeout = 10 * time.Second
+)
+
+// Kinds of spans as defined by OpenTelemetry.
+const (
+	spanKindInternal = 1
+	spanKindClient   = 3
+	spanKindConsumer = 5
+)
+
+// Tracing exports spans of the receipt and delivery of messages to an OpenTelemetry collector.
+type Tracing struct {
+	// Endpoint is the OTLP/HTTP traces endpoint, e.g. http://collector:4318/v1/traces.
+	Endpoint    string            `yaml:"endpoint"`
+	Headers     map[string]string `yaml:"headers"`
+	ServiceName string            `yaml:"service_name"`
+}
+
+func (t *Tracing) compile() error {
+	if t == nil {
+		return nil
+	}
+	if t.ServiceName == "" {
+		t.ServiceName = defaultServiceName
+	}
+	if err := checkDestination(t.Endpoint); err != nil {
+		return fmt.Errorf("invalid endpoint: %w", err)
+	}
+	return nil
+}
+
+// span is an operation of a trace. A nil span is a span of disabled tracing, all its methods
+// do nothing.
+type span struct {
+	tracer   *tracer
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	kind     int
+	start    time.Time
+	end      time.Time
+	attrs    map[string]interface{}
+	err      string
+	failed   bool
+}
+
+type spanContextKey struct{}
+
+func spanFromContext(ctx context.Context) *span {
+	s, _ := ctx.Value(spanContextKey{}).(*span)
+	return s
+}
+
+// setAttr sets an attribute, values are strings, ints or bools.
+func (s *span) setAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.attrs[key] = value
+}
+
+// finish ends the span, err is nil if the operation succeeded.
+func (s *span) finish(err error) {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	if err != nil {
+		s.failed = true
+		s.err = maskSecrets(err.Error())
+	}
+	s.tracer.enqueue(s)
+}
+
+// traceparent returns the W3C trace context header of the span.
+func (s *span) traceparent() string {
+	return fmt.Sprintf("00-%x-%x-01", s.traceID, s.spanID)
+}
+
+// inject sets the traceparent header of an outgoing request to the span.
+func (s *span) inject(header http.Header) {
+	if s == nil {
+		return
+	}
+	header.Set("traceparent", s.traceparent())
+}
+
+// spanExporter sends finished spans to a backend.
+type spanExporter interface {
+	export(ctx context.Context, spans []*span) error
+}
+
+// tracer creates spans and exports them in batches. A nil tracer creates nil spans.
+type tracer struct {
+	exporter spanExporter
+
+	mu      sync.Mutex
+	queue   []*span
+	dropped int
+}
+
+func newTracer(exporter spanExporter) *tracer {
+	return &tracer{exporter: exporter}
+}
+
+// start starts a span as child of the span in ctx and returns the context of the new span.
+func (t *tracer) start(ctx context.Context, name string, kind int) (context.Context, *span) {
+	if t == nil {
+		return ctx, nil
+	}
+	s := &span{tracer: t, name: name, kind: kind, start: time.Now(), attrs: make(map[string]interface{})}
+	if parent := spanFromContext(ctx); parent != nil {
+		s.traceID, s.parentID = parent.traceID, parent.spanID
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	rand.Read(s.spanID[:])
+	return context.WithValue(ctx, spanContextKey{}, s), s
+}
+
+func (t *tracer) enqueue(s *span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.queue) >= maxQueuedSpans {
+		t.dropped++
+		return
+	}
+	t.queue = append(t.queue, s)
+}
+
+// run exports the finished spans until ctx is done.
+func (t *tracer) run(ctx context.Context) {
+	if t == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(traceFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				t.flush()
+				return
+			case <-ticker.C:
+				t.flush()
+			}
+		}
+	}()
+}
+
+// flush exports the finished spans.
+func (t *tracer) flush() {
+	t.mu.Lock()
+	spans, dropped := t.queue, t.dropped
+	t.queue, t.dropped = nil, 0
+	t.mu.Unlock()
+
+	if dropped > 0 {
+		logger.Warn("Dropped spans, the export is too slow", slog.Int("spans", dropped))
+	}
+	if len(spans) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), traceExportTimeout)
+	defer cancel()
+	if err := t.exporter.export(ctx, spans); err != nil {
+		logger.Error("Failed to export spans", slog.Int("spans", len(spans)), slog.Any("err", err))
+	}
+}
+
+// otlpExporter exports spans with OTLP/HTTP in the JSON encoding.
+type otlpExporter struct {
+	config *Tracing
+}
+
+type otlpAttribute struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+func otlpAttributes(attrs map[string]interface{}) []otlpAttribute {
+	list := make([]otlpAttribute, 0, len(attrs))
+	for key, value := range attrs {
+		var v map[string]interface{}
+		switch value := value.(type) {
+		case bool:
+			v = map[string]interface{}{"boolValue": value}
+		case int:
+			v = map[string]interface{}{"intValue": strconv.Itoa(value)}
+		default:
+			v = map[string]interface{}{"stringValue": fmt.Sprint(value)}
+		}
+		list = append(list, otlpAttribute{Key: key, Value: v})
+	}
+	return list
+}
+
+func (e *otlpExporter) export(ctx context.Context, spans []*span) error {
+	otlpSpans := make([]map[string]interface{}, 0, len(spans))
+	for _, s := range spans {
+		status := map[string]interface{}{"code": 1}
+		if s.failed {
+			status = map[string]interface{}{"code": 2, "message": s.err}
+		}
+		otlpSpan := map[string]interface{}{
+			"traceId":           hex.EncodeToString(s.traceID[:]),
+			"spanId":            hex.EncodeToString(s.spanID[:]),
+			"name":              s.name,
+			"kind":              s.kind,
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        otlpAttributes(s.attrs),
+			"status":            status,
+		}
+		if s.parentID != [8]byte{} {
+			otlpSpan["parentSpanId"] = hex.EncodeToString(s.parentID[:])
+		}
+		otlpSpans = append(otlpSpans, otlpSpan)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": otlpAttributes(map[string]interface{}{"service.name": e.config.ServiceName}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": GetGotifyPluginInfo().ModulePath},
+				"spans": otlpSpans,
+			}},
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode spans: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.config.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.config.Headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := webhookClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send spans: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return &statusError{code: res.StatusCode}
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryExporter is a spanExporter keeping the spans in memory.
+type memoryExporter struct {
+	mu    sync.Mutex
+	spans []*span
+}
+
+func (e *memoryExporter) export(ctx context.Context, spans []*span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+// find returns the exported spans with the name.
+func (e *memoryExporter) find(name string) []*span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var spans []*span
+	for _, s := range e.spans {
+		if s.name == name {
+			spans = append(spans, s)
+		}
+	}
+	return spans
+}
+
+func TestTracing(t *testing.T) {
+	var (
+		calls       int32
+		traceparent atomic.Value
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent.Store(r.Header.Get("traceparent"))
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	p := &MultiNotifierPlugin{}
+	assert.NoError(t, p.ValidateAndSetConfig(&Config{
+		HostServer: "ws://localhost",
+		WebHooks: []*WebHook{
+			{Name: "chat", Url: server.URL, Retry: &Retry{Attempts: 2, Backoff: Duration(time.Millisecond)}},
+			{Name: "ops", Url: server.URL, Apps: AppList{IDs: []uint{2}}},
+		},
+	}))
+	exporter := &memoryExporter{}
+	p.tracer = newTracer(exporter)
+
+	assert.Empty(t, p.sendMessage(context.Background(), &MessageExternal{ID: 7, ApplicationID: 1, Title: "a"}, p.config.WebHooks))
+	p.tracer.flush()
+
+	receive := exporter.find("receive message")
+	if !assert.Len(t, receive, 1) {
+		return
+	}
+	root := receive[0]
+	assert.Equal(t, spanKindConsumer, root.kind)
+	assert.Equal(t, 7, root.attrs["gotify.message.id"])
+	assert.Equal(t, [8]byte{}, root.parentID)
+
+	outcomes := map[interface{}]interface{}{}
+	for _, s := range exporter.find("filter message") {
+		assert.Equal(t, root.spanID, s.parentID)
+		outcomes[s.attrs["webhook"]] = s.attrs["webhook.filter.outcome"]
+	}
+	assert.Equal(t, map[interface{}]interface{}{"chat": filterForwarded, "ops": filterFiltered}, outcomes)
+	assert.Len(t, exporter.find("render message"), 1)
+
+	attempts := exporter.find("POST")
+	if assert.Len(t, attempts, 2) {
+		for _, s := range attempts {
+			assert.Equal(t, root.traceID, s.traceID)
+			assert.Equal(t, root.spanID, s.parentID)
+			assert.Equal(t, spanKindClient, s.kind)
+		}
+		assert.True(t, attempts[0].failed)
+		assert.Equal(t, http.StatusServiceUnavailable, attempts[0].attrs["http.response.status_code"])
+		assert.Equal(t, 1, attempts[1].attrs["http.request.resend_count"])
+		assert.False(t, attempts[1].failed)
+		assert.Equal(t, attempts[1].traceparent(), traceparent.Load())
+	}
+}
+
+func TestTracingDisabled(t *testing.T) {
+	var tr *tracer
+	ctx, s := tr.start(context.Background(), "noop", spanKindInternal)
+	s.setAttr("a", 1)
+	s.finish(errors.New("ignored"))
+	header := http.Header{}
+	s.inject(header)
+	assert.Empty(t, header)
+	assert.Nil(t, spanFromContext(ctx))
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var (
+		request map[string]interface{}
+		auth    string
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&request)
+	}))
+	defer collector.Close()
+
+	config := &Tracing{Endpoint: collector.URL + "/v1/traces", Headers: map[string]string{"Authorization": "Bearer otel"}}
+	assert.NoError(t, config.compile())
+	assert.Error(t, (&Tracing{Endpoint: "collector:4318"}).compile())
+
+	tr := newTracer(&otlpExporter{config: config})
+	ctx, parent := tr.start(context.Background(), "receive message", spanKindConsumer)
+	_, child := tr.start(ctx, "POST", spanKindClient)
+	child.setAttr("http.response.status_code", 502)
+	child.finish(errors.New("unexpected status code: 502"))
+	parent.finish(nil)
+	tr.flush()
+
+	assert.Equal(t, "Bearer otel", auth)
+	var body struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []otlpAttribute `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID      string          `json:"traceId"`
+					SpanID       string          `json:"spanId"`
+					ParentSpanID string          `json:"parentSpanId"`
+					Name         string          `json:"name"`
+					Attributes   []otlpAttribute `json:"attributes"`
+					Status       struct {
+						Code    int    `json:"code"`
+						Message string `json:"message"`
+					} `json:"status"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	data, _ := json.Marshal(request)
+	assert.NoError(t, json.Unmarshal(data, &body))
+	if !assert.Len(t, body.ResourceSpans, 1) {
+		return
+	}
+	assert.Equal(t, []otlpAttribute{{Key: "service.name", Value: map[string]interface{}{"stringValue": defaultServiceName}}}, body.ResourceSpans[0].Resource.Attributes)
+	spans := body.ResourceSpans[0].ScopeSpans[0].Spans
+	if assert.Len(t, spans, 2) {
+		assert.Equal(t, "POST", spans[0].Name)
+		assert.Equal(t, hex.EncodeToString(parent.traceID[:]), spans[0].TraceID)
+		assert.Equal(t, hex.EncodeToString(parent.spanID[:]), spans[0].ParentSpanID)
+		assert.Equal(t, []otlpAttribute{{Key: "http.response.status_code", Value: map[string]interface{}{"intValue": "502"}}}, spans[0].Attributes)
+		assert.Equal(t, 2, spans[0].Status.Code)
+		assert.Equal(t, "unexpected status code: 502", spans[0].Status.Message)
+		assert.Empty(t, spans[1].ParentSpanID)
+		assert.Equal(t, 1, spans[1].Status.Code)
+	}
+}